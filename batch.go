@@ -0,0 +1,253 @@
+package redis_kits
+
+import (
+	"context"
+	"github.com/go-redis/redis"
+	"time"
+)
+
+//批量执行的命令结果，与添加命令的顺序一致
+type BatchResult struct {
+	//GET返回string，HSET返回bool，PEXPIRE返回bool，DEL返回删除数量int64，SET返回"OK"
+	Value interface{}
+	//GET的key不存在时为redis.Nil
+	Err error
+}
+
+//批量命令，在一个管道中执行，Cluster上由go-redis按节点拆分并行执行
+//  b := client.NewBatch()
+//  b.Set("a", 1, time.Minute)
+//  get := b.Get("b")
+//  results, err := b.Exec(ctx)
+//  value, err := results[get].Value, results[get].Err
+type Batch struct {
+	client pipelineClient
+	ops    []batchOp
+	count  int
+}
+
+//管道中的一条命令，跨slot的DEL拆分为多条，index相同的结果合并
+type batchOp struct {
+	index int
+	queue func(p redis.Pipeliner) redis.Cmder
+}
+
+func newBatch(client pipelineClient) *Batch {
+	return &Batch{client: client}
+}
+
+func (c *redisStandard) NewBatch() *Batch {
+	return newBatch(c)
+}
+
+func (c *redisCluster) NewBatch() *Batch {
+	return newBatch(c)
+}
+
+func (b *Batch) add(queue func(p redis.Pipeliner) redis.Cmder) int {
+	index := b.count
+	b.count++
+	b.ops = append(b.ops, batchOp{index: index, queue: queue})
+	return index
+}
+
+//添加SET命令，返回结果序号
+func (b *Batch) Set(key string, value interface{}, ttl time.Duration) int {
+	return b.add(func(p redis.Pipeliner) redis.Cmder {
+		return p.Set(key, value, ttl)
+	})
+}
+
+//添加GET命令，返回结果序号
+func (b *Batch) Get(key string) int {
+	return b.add(func(p redis.Pipeliner) redis.Cmder {
+		return p.Get(key)
+	})
+}
+
+//添加HSET命令，返回结果序号
+func (b *Batch) HSet(key string, field string, value interface{}) int {
+	return b.add(func(p redis.Pipeliner) redis.Cmder {
+		return p.HSet(key, field, value)
+	})
+}
+
+//添加PEXPIRE命令，返回结果序号，ttl小于1毫秒时结果为错误且不发送命令
+func (b *Batch) Expire(key string, ttl time.Duration) int {
+	return b.add(func(p redis.Pipeliner) redis.Cmder {
+		if err := checkExpireTTL(ttl); err != nil {
+			return redis.NewBoolResult(false, err)
+		}
+		return p.PExpire(key, ttl)
+	})
+}
+
+//添加DEL命令，返回结果序号，Cluster上跨slot的key拆分为多条DEL，结果为删除数量之和
+func (b *Batch) Del(keys ...string) int {
+	groups := map[int][]string{0: keys}
+	if b.client.slotted() {
+		groups = groupBySlot(keys)
+	}
+
+	index := b.count
+	b.count++
+	if len(keys) == 0 {
+		return index
+	}
+	for _, group := range groups {
+		group := group
+		b.ops = append(b.ops, batchOp{index: index, queue: func(p redis.Pipeliner) redis.Cmder {
+			return p.Del(group...)
+		}})
+	}
+	return index
+}
+
+//命令数量
+func (b *Batch) Len() int {
+	return b.count
+}
+
+//执行所有命令，返回与添加顺序一致的结果和第一个错误(不包括redis.Nil)
+func (b *Batch) Exec(ctx context.Context) ([]BatchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	//Cluster上go-redis按节点拆分管道并行执行，MOVED和ASK时重新路由
+	cmds := make([]redis.Cmder, len(b.ops))
+	p := b.client.pipeline()
+	defer p.Close()
+	for i, op := range b.ops {
+		cmds[i] = op.queue(p)
+	}
+	//每条命令的错误保存在各自的Cmder中
+	p.Exec()
+
+	results := make([]BatchResult, b.count)
+	for i, op := range b.ops {
+		merge(&results[op.index], cmds[i])
+	}
+
+	var first error
+	for _, result := range results {
+		if result.Err != nil && result.Err != redis.Nil {
+			first = result.Err
+			break
+		}
+	}
+	return results, first
+}
+
+func merge(result *BatchResult, cmd redis.Cmder) {
+	if err := cmd.Err(); err != nil {
+		if result.Err == nil || result.Err == redis.Nil {
+			result.Err = err
+		}
+		return
+	}
+
+	switch cmd := cmd.(type) {
+	case *redis.IntCmd:
+		//拆分后的DEL合并删除数量
+		n, _ := result.Value.(int64)
+		result.Value = n + cmd.Val()
+	case *redis.StringCmd:
+		result.Value = cmd.Val()
+	case *redis.StatusCmd:
+		result.Value = cmd.Val()
+	case *redis.BoolCmd:
+		result.Value = cmd.Val()
+	case *redis.SliceCmd:
+		result.Value = cmd.Val()
+	}
+}
+
+//BatchGet的结果
+type BatchValue struct {
+	Key   string
+	Value string
+	//key不存在时为false
+	Found bool
+}
+
+//BatchSetMap的值和过期时间，TTL为0时不过期
+type BatchEntry struct {
+	Value interface{}
+	TTL   time.Duration
+}
+
+func (c *redisStandard) BatchGet(keys []string) ([]BatchValue, error) {
+	return batchGet(c, keys)
+}
+
+func (c *redisCluster) BatchGet(keys []string) ([]BatchValue, error) {
+	return batchGet(c, keys)
+}
+
+func (c *redisStandard) BatchSetMap(entries map[string]BatchEntry) error {
+	return batchSetMap(c, entries)
+}
+
+func (c *redisCluster) BatchSetMap(entries map[string]BatchEntry) error {
+	return batchSetMap(c, entries)
+}
+
+//使用MGET读取，Cluster上按slot拆分为多条MGET并按节点并行执行
+func batchGet(client pipelineClient, keys []string) ([]BatchValue, error) {
+	values := make([]BatchValue, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	//每个slot的key在keys中的位置
+	positions := make(map[int][]int)
+	for i, key := range keys {
+		slot := 0
+		if client.slotted() {
+			slot = KeySlot(key)
+		}
+		positions[slot] = append(positions[slot], i)
+	}
+
+	b := newBatch(client)
+	groups := make(map[int][]int, len(positions))
+	for _, group := range positions {
+		group := group
+		groupKeys := make([]string, len(group))
+		for i, pos := range group {
+			groupKeys[i] = keys[pos]
+		}
+		index := b.add(func(p redis.Pipeliner) redis.Cmder {
+			return p.MGet(groupKeys...)
+		})
+		groups[index] = group
+	}
+
+	results, err := b.Exec(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	for index, group := range groups {
+		replies, _ := results[index].Value.([]interface{})
+		for i, pos := range group {
+			values[pos].Key = keys[pos]
+			if i < len(replies) {
+				if value, ok := replies[i].(string); ok {
+					values[pos].Value = value
+					values[pos].Found = true
+				}
+			}
+		}
+	}
+	return values, nil
+}
+
+func batchSetMap(client pipelineClient, entries map[string]BatchEntry) error {
+	b := newBatch(client)
+	for key, entry := range entries {
+		b.Set(key, entry.Value, entry.TTL)
+	}
+	_, err := b.Exec(context.Background())
+	return err
+}
@@ -0,0 +1,160 @@
+package redis_kits
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis"
+	"time"
+)
+
+//DeleteByPattern和ExpireByPattern的参数
+type BulkOptions struct {
+	//只统计匹配的key数量，不执行删除或设置过期
+	DryRun bool
+	//每批SCAN和处理的key数量，默认500
+	BatchSize int64
+	//两批之间的间隔，降低对服务端的压力
+	Interval time.Duration
+}
+
+//支持管道的客户端，slotted为true时多key命令需要按slot拆分
+type pipelineClient interface {
+	RedisClient
+	pipeline() redis.Pipeliner
+	slotted() bool
+}
+
+func (c *redisStandard) pipeline() redis.Pipeliner {
+	return c.conn().Pipeline()
+}
+
+func (c *redisStandard) slotted() bool {
+	return false
+}
+
+func (c *redisCluster) pipeline() redis.Pipeliner {
+	return c.conn().Pipeline()
+}
+
+func (c *redisCluster) slotted() bool {
+	return true
+}
+
+func (c *redisStandard) DeleteByPattern(ctx context.Context, pattern string, opt *BulkOptions) (int64, error) {
+	return deleteByPattern(ctx, c, pattern, opt)
+}
+
+func (c *redisStandard) ExpireByPattern(ctx context.Context, pattern string, ttl time.Duration, opt *BulkOptions) (int64, error) {
+	return expireByPattern(ctx, c, pattern, ttl, opt)
+}
+
+func (c *redisCluster) DeleteByPattern(ctx context.Context, pattern string, opt *BulkOptions) (int64, error) {
+	return deleteByPattern(ctx, c, pattern, opt)
+}
+
+func (c *redisCluster) ExpireByPattern(ctx context.Context, pattern string, ttl time.Duration, opt *BulkOptions) (int64, error) {
+	return expireByPattern(ctx, c, pattern, ttl, opt)
+}
+
+//使用UNLINK删除，返回实际删除的数量
+func deleteByPattern(ctx context.Context, client pipelineClient, pattern string, opt *BulkOptions) (int64, error) {
+	return forEachBatch(ctx, client, pattern, opt, func(p redis.Pipeliner, keys []string) func() int64 {
+		var cmds []*redis.IntCmd
+		if client.slotted() {
+			for _, group := range groupBySlot(keys) {
+				cmds = append(cmds, p.Unlink(group...))
+			}
+		} else {
+			cmds = append(cmds, p.Unlink(keys...))
+		}
+
+		return func() int64 {
+			var n int64
+			for _, cmd := range cmds {
+				n += cmd.Val()
+			}
+			return n
+		}
+	})
+}
+
+//返回成功设置过期时间的数量，ttl小于1毫秒时返回错误
+func expireByPattern(ctx context.Context, client pipelineClient, pattern string, ttl time.Duration, opt *BulkOptions) (int64, error) {
+	if err := checkExpireTTL(ttl); err != nil {
+		return 0, err
+	}
+	return forEachBatch(ctx, client, pattern, opt, func(p redis.Pipeliner, keys []string) func() int64 {
+		cmds := make([]*redis.BoolCmd, len(keys))
+		for i, key := range keys {
+			cmds[i] = p.PExpire(key, ttl)
+		}
+
+		return func() int64 {
+			var n int64
+			for _, cmd := range cmds {
+				if cmd.Val() {
+					n++
+				}
+			}
+			return n
+		}
+	})
+}
+
+//PEXPIRE的过期时间为毫秒，小于1毫秒时截断为0会直接删除key
+func checkExpireTTL(ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return fmt.Errorf("ttl %v must be at least 1ms", ttl)
+	}
+	return nil
+}
+
+//按批遍历匹配的key，每批在一个管道中执行，queue向管道添加命令并返回统计结果的函数
+func forEachBatch(ctx context.Context, client pipelineClient, pattern string, opt *BulkOptions,
+	queue func(p redis.Pipeliner, keys []string) func() int64) (int64, error) {
+	o := BulkOptions{}
+	if opt != nil {
+		o = *opt
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 500
+	}
+
+	it := client.ScanKeys(ctx, &ScanOptions{Match: pattern, Count: o.BatchSize, Interval: o.Interval})
+	var total int64
+	batch := make([]string, 0, o.BatchSize)
+	flush := func() error {
+		defer func() { batch = batch[:0] }()
+		if o.DryRun {
+			total += int64(len(batch))
+			return nil
+		}
+
+		p := client.pipeline()
+		defer p.Close()
+		count := queue(p, batch)
+		if _, err := p.Exec(); err != nil {
+			return err
+		}
+		total += count()
+		return nil
+	}
+
+	for it.Next() {
+		batch = append(batch, it.Key())
+		if int64(len(batch)) >= o.BatchSize {
+			if err := flush(); err != nil {
+				return total, err
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		return total, err
+	}
+	if len(batch) != 0 {
+		if err := flush(); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
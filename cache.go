@@ -4,13 +4,31 @@ import (
 	"fmt"
 )
 
+//同一个缓存的数据、交换和锁key使用相同的hash tag，在Cluster上位于同一个slot
+var (
+	cacheKeys     = NewKeyBuilder("REDIS:CACHE")
+	cacheSwapKeys = NewKeyBuilder("REDIS:CACHE-SWAP")
+	cacheLockKeys = NewKeyBuilder("REDIS:CACHE-LOCK")
+)
+
 func getCacheName(name string) string {
-	return fmt.Sprintf("REDIS:CACHE:%s", name)
+	return cacheKeys.Key(name)
 }
 func getCacheSwapName(name string) string {
-	return fmt.Sprintf("REDIS:CACHE-SWAP:%s", name)
+	return cacheSwapKeys.Key(name)
 }
 func getCacheLockName(name string) string {
+	return cacheLockKeys.Key(name)
+}
+
+//不带hash tag的旧格式key，兼容升级前写入的数据和滚动升级期间的旧版本
+func getLegacyCacheName(name string) string {
+	return fmt.Sprintf("REDIS:CACHE:%s", name)
+}
+func getLegacyCacheSwapName(name string) string {
+	return fmt.Sprintf("REDIS:CACHE-SWAP:%s", name)
+}
+func getLegacyCacheLockName(name string) string {
 	return fmt.Sprintf("REDIS:CACHE-LOCK:%s", name)
 }
 
@@ -22,11 +40,24 @@ type cacheManager struct {
 	caches map[string]*cache
 }
 
-//缓存数据
+//新格式或旧格式的同步锁是否存在
+func (i *cache) locked(client RedisClient) (bool, error) {
+	for _, lockName := range []string{getCacheLockName(i.cacheName), getLegacyCacheLockName(i.cacheName)} {
+		exists, err := client.Exists(lockName)
+		if err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
+//缓存数据，缓存名称作为hash tag，不能为空，也不能包含{和}
 func (i *cache) Push(client RedisClient, key string, value interface{}) error {
-	lockName := getCacheLockName(i.cacheName)
+	if err := ValidateTag(i.cacheName); err != nil {
+		return err
+	}
 	listName := getCacheName(i.cacheName)
-	exists, err := client.Exists(lockName)
+	exists, err := i.locked(client)
 	if nil != err {
 		return err
 	}
@@ -38,10 +69,12 @@ func (i *cache) Push(client RedisClient, key string, value interface{}) error {
 	return client.SetHash(listName, key, value)
 }
 
-//缓存上锁
+//缓存上锁，同时设置旧格式的锁，滚动升级期间旧版本写入交换队列
 func (i *cache) Lock(client RedisClient) error {
-	lockName := getCacheLockName(i.cacheName)
-	exists, err := client.Exists(lockName)
+	if err := ValidateTag(i.cacheName); err != nil {
+		return err
+	}
+	exists, err := i.locked(client)
 	if nil != err {
 		return err
 	}
@@ -49,34 +82,52 @@ func (i *cache) Lock(client RedisClient) error {
 		//缓存同步锁存在
 		return nil
 	}
-	return client.SetNX(lockName, "", 0)
+	if err := client.SetNX(getCacheLockName(i.cacheName), "", 0); err != nil {
+		return err
+	}
+	return client.SetNX(getLegacyCacheLockName(i.cacheName), "", 0)
 }
 
 func (i *cache) Unlock(client RedisClient) error {
-	lockName := getCacheLockName(i.cacheName)
-	return client.Delete(lockName)
+	if err := ValidateTag(i.cacheName); err != nil {
+		return err
+	}
+	if err := client.Delete(getCacheLockName(i.cacheName)); err != nil {
+		return err
+	}
+	return client.Delete(getLegacyCacheLockName(i.cacheName))
 }
 
-func (i *cache) PopAll(client RedisClient, data map[string]string) error {
-	swapListName := getCacheSwapName(i.cacheName)
-	listName := getCacheName(i.cacheName)
+//读取并删除hash，旧格式的key在Cluster上可能位于其他slot，逐个读取
+func popHashes(client RedisClient, data map[string]string, names ...string) error {
+	for _, name := range names {
+		result, err := client.GetHashAll(name)
+		if err != nil {
+			return err
+		}
+		if nil != result {
+			for k, v := range result {
+				data[k] = v
+			}
+		}
+		if err = client.Delete(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	//获取交换队列数据
-	result, err := client.GetHashAll(swapListName)
-	if err != nil {
+func (i *cache) PopAll(client RedisClient, data map[string]string) error {
+	if err := ValidateTag(i.cacheName); err != nil {
 		return err
 	}
-	if nil != result {
-		for k, v := range result {
-			data[k] = v
-		}
-	}
 
-	if err = client.Delete(swapListName); err != nil {
+	//获取交换队列数据，包括旧版本写入的数据
+	if err := popHashes(client, data, getLegacyCacheSwapName(i.cacheName), getCacheSwapName(i.cacheName)); err != nil {
 		return err
 	}
 
-	if err = i.Lock(client); err != nil {
+	if err := i.Lock(client); err != nil {
 		return nil
 	}
 
@@ -84,17 +135,7 @@ func (i *cache) PopAll(client RedisClient, data map[string]string) error {
 		i.Unlock(client)
 	}()
 
-	result, err = client.GetHashAll(listName)
-	if err != nil {
-		return err
-	}
-	if nil != result {
-		for k, v := range result {
-			data[k] = v
-		}
-	}
-
-	return client.Delete(listName)
+	return popHashes(client, data, getLegacyCacheName(i.cacheName), getCacheName(i.cacheName))
 }
 
 var cm *cacheManager
@@ -1,88 +1,232 @@
 package redis_kits
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/go-redis/redis"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type redisStandard struct {
-	client *redis.Client
+	swappable
+	//当前连接的数据库和地址，热加载时更新
+	target atomic.Value
+
+	//配置了Sentinel时的主节点切换监听，热加载时替换，Close时释放
+	watcherMu sync.Mutex
+	watcher   *sentinelWatcher
+}
+
+//单机客户端连接的目标，配置了Username时go-redis的DB始终为0，需要单独记录实际的数据库
+type standardTarget struct {
+	db int
+	//Sentinel模式下为master名称
+	addr string
 }
 
-var c RedisClient
+var (
+	//保护c、clients和cfg，初始化、热加载和关闭时加写锁
+	clientMu sync.RWMutex
+	c        RedisClient
+	clients  = make(map[int]RedisClient)
+)
 
+//获取默认客户端，并发调用返回同一个实例
 func GetClient() (RedisClient, error) {
-	if c == nil {
-		cfg, err := GetConfig()
-		if err != nil {
-			return nil, err
-		}
+	clientMu.RLock()
+	client := c
+	clientMu.RUnlock()
+	if client != nil {
+		return client, nil
+	}
 
-		if len(cfg.clusterAddress) != 0 {
-			c = &redisCluster{
-				client: redis.NewClusterClient(&redis.ClusterOptions{
-					Addrs:        cfg.clusterAddress,
-					MaxRetries:   3,
-					PoolSize:     cfg.poolSize,
-					MinIdleConns: cfg.minIdle,
-				}),
-			}
-		} else {
-			c = &redisStandard{client: redis.NewClient(&redis.Options{
-				Addr:         fmt.Sprintf("%s:%d", cfg.address, cfg.port),
-				Password:     cfg.password,
-				DB:           cfg.database,
-				MaxRetries:   3,
-				PoolSize:     cfg.poolSize,
-				MinIdleConns: cfg.minIdle,
-			})}
-		}
+	clientMu.Lock()
+	defer clientMu.Unlock()
+	return defaultClient()
+}
+
+//调用时需持有clientMu写锁
+func defaultClient() (RedisClient, error) {
+	if c != nil {
+		return c, nil
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := NewClient(&cfg.Options)
+	if err != nil {
+		return nil, err
 	}
+	c = client
 	return c, nil
 }
 
-var clients = make(map[int]RedisClient)
-
+//获取指定数据库的客户端，Cluster只有数据库0，返回与GetClient相同的客户端
 func GetClientByIndex(index int) (RedisClient, error) {
+	clientMu.RLock()
 	client, ok := clients[index]
+	clientMu.RUnlock()
 	if ok {
 		return client, nil
 	}
 
-	cfg, err := GetConfig()
+	clientMu.Lock()
+	defer clientMu.Unlock()
+	if client, ok := clients[index]; ok {
+		return client, nil
+	}
+
+	cfg, err := loadConfig()
 	if err != nil {
 		return nil, err
 	}
+	if len(cfg.ClusterAddress) != 0 {
+		if index != 0 {
+			return nil, fmt.Errorf("redis cluster only supports database 0, got database %d", index)
+		}
+		return defaultClient()
+	}
 
-	client = &redisStandard{client: redis.NewClient(&redis.Options{
-		Addr:         fmt.Sprintf("%s:%d", cfg.address, cfg.port),
-		Password:     cfg.password,
-		DB:           index,
-		MaxRetries:   3,
-		PoolSize:     cfg.poolSize,
-		MinIdleConns: cfg.minIdle,
-	})}
-
+	client, err = newStandardClient(&cfg.Options, index)
+	if err != nil {
+		return nil, err
+	}
 	clients[index] = client
 	return client, nil
 }
 
+func newStandardClient(opt *Options, db int) (*redisStandard, error) {
+	client, sentinel, err := newRawStandard(opt, db)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisStandard{}
+	c.swap(client)
+	c.target.Store(newStandardTarget(opt, db))
+	c.setSentinel(sentinel)
+	return c, nil
+}
+
+//替换主节点切换监听，sentinel为nil时只释放原来的监听
+//先获取新的监听再释放旧的，配置没有变化时继续使用同一个监听
+func (c *redisStandard) setSentinel(sentinel *sentinelConfig) {
+	var next *sentinelWatcher
+	if sentinel != nil {
+		next = acquireSentinelWatcher(sentinel)
+	}
+	c.watcherMu.Lock()
+	prev := c.watcher
+	c.watcher = next
+	c.watcherMu.Unlock()
+	if prev != nil {
+		prev.release()
+	}
+}
+
+//创建go-redis单机客户端，配置了Sentinel时通过Sentinel自动发现主节点，并返回主节点切换监听的参数
+func newRawStandard(opt *Options, db int) (*redis.Client, *sentinelConfig, error) {
+	tlsConfig, err := opt.TLS.tlsConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	password, db, onConnect := opt.auth(db)
+
+	if len(opt.SentinelAddress) != 0 {
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    opt.SentinelMaster,
+			SentinelAddrs: opt.SentinelAddress,
+			OnConnect:     onConnect,
+			Password:      password,
+			DB:            db,
+			PoolSize:      opt.PoolSize,
+			MinIdleConns:  opt.MinIdle,
+			TLSConfig:     tlsConfig,
+
+			MaxRetries:         opt.maxRetries(),
+			MinRetryBackoff:    opt.MinRetryBackoff,
+			MaxRetryBackoff:    opt.MaxRetryBackoff,
+			DialTimeout:        opt.DialTimeout,
+			ReadTimeout:        opt.ReadTimeout,
+			WriteTimeout:       opt.WriteTimeout,
+			PoolTimeout:        opt.PoolTimeout,
+			IdleTimeout:        opt.IdleTimeout,
+			IdleCheckFrequency: opt.IdleCheckFrequency,
+			MaxConnAge:         opt.MaxConnAge,
+		}), newSentinelConfig(opt, tlsConfig), nil
+	}
+
+	return redis.NewClient(&redis.Options{
+		Network:      opt.Network,
+		Addr:         opt.addr(),
+		OnConnect:    onConnect,
+		Password:     password,
+		DB:           db,
+		PoolSize:     opt.PoolSize,
+		MinIdleConns: opt.MinIdle,
+		TLSConfig:    tlsConfig,
+
+		MaxRetries:         opt.maxRetries(),
+		MinRetryBackoff:    opt.MinRetryBackoff,
+		MaxRetryBackoff:    opt.MaxRetryBackoff,
+		DialTimeout:        opt.DialTimeout,
+		ReadTimeout:        opt.ReadTimeout,
+		WriteTimeout:       opt.WriteTimeout,
+		PoolTimeout:        opt.PoolTimeout,
+		IdleTimeout:        opt.IdleTimeout,
+		IdleCheckFrequency: opt.IdleCheckFrequency,
+		MaxConnAge:         opt.MaxConnAge,
+	}), nil, nil
+}
+
+func newStandardTarget(opt *Options, db int) standardTarget {
+	if len(opt.SentinelAddress) != 0 {
+		return standardTarget{db: db, addr: opt.SentinelMaster}
+	}
+	return standardTarget{db: db, addr: opt.addr()}
+}
+
+func (c *redisStandard) conn() *redis.Client {
+	return c.load().(*redis.Client)
+}
+
+//使用新配置创建go-redis客户端，apply时替换，旧客户端上的命令执行完成后关闭
+func (c *redisStandard) prepareReload(opt *Options, db int) (*pendingReload, error) {
+	if len(opt.ClusterAddress) != 0 {
+		return nil, errors.New("cannot switch from standalone to cluster without restart")
+	}
+	client, sentinel, err := newRawStandard(opt, db)
+	if err != nil {
+		return nil, err
+	}
+	target := newStandardTarget(opt, db)
+	return &pendingReload{client: client, apply: func() {
+		c.swap(client)
+		c.target.Store(target)
+		c.setSentinel(sentinel)
+	}}, nil
+}
+
+//使用SCAN遍历，不使用会阻塞服务端的KEYS命令
 func (c *redisStandard) GetKeys(keyLike string) ([]string, error) {
-	return c.client.Keys(keyLike).Result()
+	return c.ScanKeys(context.Background(), &ScanOptions{Match: keyLike, Count: 1000}).All()
 }
 
 func (c *redisStandard) Set(key string, value interface{}, timeout time.Duration) error {
-	if _, err := c.client.Set(key, value, timeout).Result(); err != nil {
+	if _, err := c.conn().Set(key, value, timeout).Result(); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (c *redisStandard) SetNX(key string, value interface{}, timeout time.Duration) error {
-	if _, err := c.client.SetNX(key, value, timeout).Result(); err != nil {
+	if _, err := c.conn().SetNX(key, value, timeout).Result(); err != nil {
 		return err
 	}
 	return nil
@@ -90,24 +234,24 @@ func (c *redisStandard) SetNX(key string, value interface{}, timeout time.Durati
 
 func (c *redisStandard) FlushAll() error {
 
-	_, err := c.client.FlushAll().Result()
+	_, err := c.conn().FlushAll().Result()
 	return err
 }
 
 func (c *redisStandard) FlushDB() error {
-	_, err := c.client.FlushDB().Result()
+	_, err := c.conn().FlushDB().Result()
 	return err
 }
 
 func (c *redisStandard) Delete(key ...string) error {
-	if _, err := c.client.Del(key...).Result(); err != nil {
+	if _, err := c.conn().Del(key...).Result(); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (c *redisStandard) Incr(key string) (int64, error) {
-	return c.client.Incr(key).Result()
+	return c.conn().Incr(key).Result()
 }
 
 func (c *redisStandard) IncrAtExpire(key string, dur time.Duration) (int64, error) {
@@ -123,29 +267,29 @@ func (c *redisStandard) IncrAtExpire(key string, dur time.Duration) (int64, erro
 }
 
 func (c *redisStandard) RPush(key string, value interface{}) error {
-	_, err := c.client.RPush(key, value).Result()
+	_, err := c.conn().RPush(key, value).Result()
 	return err
 }
 func (c *redisStandard) LPush(key string, value interface{}) error {
-	_, err := c.client.LPush(key, value).Result()
+	_, err := c.conn().LPush(key, value).Result()
 	return err
 }
 
 func (c *redisStandard) LTrim(key string, start int64, end int64) error {
-	_, err := c.client.LTrim(key, start, end).Result()
+	_, err := c.conn().LTrim(key, start, end).Result()
 	return err
 }
 
 func (c *redisStandard) Subscribe(channel string) *redis.PubSub {
-	return c.client.Subscribe(channel)
+	return c.conn().Subscribe(channel)
 }
 
 func (c *redisStandard) Publish(channel string, value interface{}) error {
-	return c.client.Publish(channel, value).Err()
+	return c.conn().Publish(channel, value).Err()
 }
 
 func (c *redisStandard) Get(key string) string {
-	value, err := c.client.Get(key).Result()
+	value, err := c.conn().Get(key).Result()
 	if nil != err {
 		return ""
 	}
@@ -153,12 +297,12 @@ func (c *redisStandard) Get(key string) string {
 }
 
 func (c *redisStandard) Ping() error {
-	_, err := c.client.Ping().Result()
+	_, err := c.conn().Ping().Result()
 	return err
 }
 
 func (c *redisStandard) Exists(key string) (bool, error) {
-	i, err := c.client.Exists(key).Result()
+	i, err := c.conn().Exists(key).Result()
 	if err == redis.Nil {
 		return false, nil
 	}
@@ -166,16 +310,16 @@ func (c *redisStandard) Exists(key string) (bool, error) {
 }
 
 func (c *redisStandard) Expire(key string, duration time.Duration) (bool, error) {
-	return c.client.Expire(key, duration).Result()
+	return c.conn().Expire(key, duration).Result()
 }
 
 func (c *redisStandard) Pull(key string) ([]string, error) {
-	len, err := c.client.LLen(key).Result()
+	len, err := c.conn().LLen(key).Result()
 	if err != nil {
 		return nil, err
 	}
 	if len > 0 {
-		values, err := c.client.LRange(key, 0, len).Result()
+		values, err := c.conn().LRange(key, 0, len).Result()
 		if err != nil {
 			return nil, err
 		}
@@ -184,16 +328,16 @@ func (c *redisStandard) Pull(key string) ([]string, error) {
 	return nil, nil
 }
 func (c *redisStandard) Pop(key string) ([]string, error) {
-	len, err := c.client.LLen(key).Result()
+	len, err := c.conn().LLen(key).Result()
 	if err != nil {
 		return nil, err
 	}
 	if len > 0 {
-		values, err := c.client.LRange(key, 0, len).Result()
+		values, err := c.conn().LRange(key, 0, len).Result()
 		if err != nil {
 			return nil, err
 		}
-		result, err := c.client.Del(key).Result()
+		result, err := c.conn().Del(key).Result()
 		if err != nil {
 			return nil, err
 		}
@@ -206,30 +350,30 @@ func (c *redisStandard) Pop(key string) ([]string, error) {
 }
 
 func (c *redisStandard) SetHash(key string, field string, value interface{}) error {
-	_, err := c.client.HSet(key, field, value).Result()
+	_, err := c.conn().HSet(key, field, value).Result()
 	if err != nil {
 		return err
 	}
 	return nil
 }
 func (c *redisStandard) GetHash(key string, field string) (string, error) {
-	return c.client.HGet(key, field).Result()
+	return c.conn().HGet(key, field).Result()
 }
 
 func (c *redisStandard) GetHashAll(key string) (map[string]string, error) {
-	return c.client.HGetAll(key).Result()
+	return c.conn().HGetAll(key).Result()
 }
 
 func (c *redisStandard) GetHashAllMapKey(key string) ([]string, error) {
-	return c.client.HKeys(key).Result()
+	return c.conn().HKeys(key).Result()
 }
 
 func (c *redisStandard) HashDelete(key string, field string) (int64, error) {
-	return c.client.HDel(key, field).Result()
+	return c.conn().HDel(key, field).Result()
 }
 
 func (c *redisStandard) BatchSet(keys []string, value []interface{}, expire int) error {
-	p := c.client.Pipeline()
+	p := c.conn().Pipeline()
 	expired := time.Duration(expire) * time.Second
 	for i, k := range keys {
 		p.Set(k, value[i], expired)
@@ -242,29 +386,34 @@ func (c *redisStandard) BatchSet(keys []string, value []interface{}, expire int)
 	return nil
 }
 
+func (c *redisStandard) Close() error {
+	c.setSentinel(nil)
+	return c.swappable.close()
+}
+
 func (c *redisStandard) GetRaw() redis.Cmdable {
-	return c.client
+	return c.conn()
 }
 
 func (c *redisStandard) ZAdd(key string, uuid string, score float64) error {
-	_, err := c.client.Do("ZADD", key, score, uuid).Result()
+	_, err := c.conn().Do("ZADD", key, score, uuid).Result()
 	return err
 }
 
 func (c *redisStandard) ZRevRank(key string, uuid string) (int64, error) {
-	return c.client.ZRevRank(key, uuid).Result()
+	return c.conn().ZRevRank(key, uuid).Result()
 }
 
 func (c *redisStandard) ZRank(key string, uuid string) (int64, error) {
-	return c.client.ZRank(key, uuid).Result()
+	return c.conn().ZRank(key, uuid).Result()
 }
 
 func (c *redisStandard) ZScore(key string, uuid string) (float64, error) {
-	return c.client.ZScore(key, uuid).Result()
+	return c.conn().ZScore(key, uuid).Result()
 }
 
 func (c *redisStandard) ZIncrBy(key string, scoreInc float64, uuid string) (float64, error) {
-	return c.client.ZIncrBy(key, scoreInc, uuid).Result()
+	return c.conn().ZIncrBy(key, scoreInc, uuid).Result()
 }
 
 func (c *redisStandard) ZRangeByScoreWithScores(key string, minScore float64, maxScore float64) ([]redis.Z, error) {
@@ -272,7 +421,7 @@ func (c *redisStandard) ZRangeByScoreWithScores(key string, minScore float64, ma
 		Min: strconv.FormatFloat(minScore, 'E', -1, 64),
 		Max: strconv.FormatFloat(maxScore, 'E', -1, 64),
 	}
-	return c.client.ZRangeByScoreWithScores(key, op).Result()
+	return c.conn().ZRangeByScoreWithScores(key, op).Result()
 }
 
 func (c *redisStandard) ZRevRangeByScoreWithScores(key string, minScore float64, maxScore float64) ([]redis.Z, error) {
@@ -280,51 +429,55 @@ func (c *redisStandard) ZRevRangeByScoreWithScores(key string, minScore float64,
 		Min: strconv.FormatFloat(minScore, 'E', -1, 64),
 		Max: strconv.FormatFloat(maxScore, 'E', -1, 64),
 	}
-	return c.client.ZRevRangeByScoreWithScores(key, op).Result()
+	return c.conn().ZRevRangeByScoreWithScores(key, op).Result()
 }
 
 func (c *redisStandard) ZRangeWithScores(key string, minRank int64, maxRank int64) ([]redis.Z, error) {
-	return c.client.ZRangeWithScores(key, minRank, maxRank).Result()
+	return c.conn().ZRangeWithScores(key, minRank, maxRank).Result()
 }
 
 func (c *redisStandard) ZRevRangeWithScores(key string, minRank int64, maxRank int64) ([]redis.Z, error) {
-	return c.client.ZRevRangeWithScores(key, minRank, maxRank).Result()
+	return c.conn().ZRevRangeWithScores(key, minRank, maxRank).Result()
 }
 
 func (c *redisStandard) ZRemRangeByRank(key string, minRank int64, maxRank int64) (int64, error) {
-	return c.client.ZRemRangeByRank(key, minRank, maxRank).Result()
+	return c.conn().ZRemRangeByRank(key, minRank, maxRank).Result()
 }
 
 func (c *redisStandard) ZRem(key string, members ...interface{}) (int64, error) {
-	return c.client.ZRem(key, members).Result()
+	return c.conn().ZRem(key, members).Result()
 }
 
 func (c *redisStandard) ZRemRangeByScore(key string, min string, max string) (int64, error) {
-	return c.client.ZRemRangeByScore(key, min, max).Result()
+	return c.conn().ZRemRangeByScore(key, min, max).Result()
 }
 
 func (c *redisStandard) SetsAdd(key string, value interface{}) error {
-	_, err := c.client.SAdd(key, value).Result()
+	_, err := c.conn().SAdd(key, value).Result()
 	return err
 }
 
 func (c *redisStandard) SetsDel(key string, value interface{}) error {
-	_, err := c.client.SRem(key, value).Result()
+	_, err := c.conn().SRem(key, value).Result()
 	return err
 }
 
 func (c *redisStandard) SetsCard(key string) (int64, error) {
-	return c.client.SCard(key).Result()
+	return c.conn().SCard(key).Result()
 }
 
 func (c *redisStandard) SetsMembers(key string) ([]string, error) {
-	return c.client.SMembers(key).Result()
+	return c.conn().SMembers(key).Result()
 }
 
 func (c *redisStandard) SetsExistMember(key string, member string) (bool, error) {
-	return c.client.SIsMember(key, member).Result()
+	return c.conn().SIsMember(key, member).Result()
 }
 
 func (c *redisStandard) Scan(cursor uint64, key string, count int64) ([]string, uint64, error) {
-	return c.client.Scan(cursor, key, count).Result()
+	return c.conn().Scan(cursor, key, count).Result()
+}
+
+func (c *redisStandard) ScanKeys(ctx context.Context, opt *ScanOptions) *KeyIterator {
+	return newKeyIterator(ctx, []*redis.Client{c.conn()}, false, opt)
 }
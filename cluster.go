@@ -1,69 +1,152 @@
 package redis_kits
 
 import (
+	"context"
 	"errors"
 	"github.com/go-redis/redis"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
 )
 
+const (
+	//Scan游标中主节点序号的位置，Redis的游标不会用到高12位
+	clusterCursorShift = 52
+	clusterCursorMask  = 1<<clusterCursorShift - 1
+)
+
 type redisCluster struct {
-	client *redis.ClusterClient
+	swappable
 }
 
+func newClusterClient(opt *Options) (*redisCluster, error) {
+	client, err := newRawCluster(opt)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisCluster{}
+	c.swap(client)
+	return c, nil
+}
+
+func newRawCluster(opt *Options) (*redis.ClusterClient, error) {
+	tlsConfig, err := opt.TLS.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	password, _, onConnect := opt.auth(0)
+
+	return redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:        opt.ClusterAddress,
+		OnConnect:    onConnect,
+		Password:     password,
+		PoolSize:     opt.PoolSize,
+		MinIdleConns: opt.MinIdle,
+		TLSConfig:    tlsConfig,
+
+		MaxRetries:         opt.maxRetries(),
+		MinRetryBackoff:    opt.MinRetryBackoff,
+		MaxRetryBackoff:    opt.MaxRetryBackoff,
+		DialTimeout:        opt.DialTimeout,
+		ReadTimeout:        opt.ReadTimeout,
+		WriteTimeout:       opt.WriteTimeout,
+		PoolTimeout:        opt.PoolTimeout,
+		IdleTimeout:        opt.IdleTimeout,
+		IdleCheckFrequency: opt.IdleCheckFrequency,
+		MaxConnAge:         opt.MaxConnAge,
+	}), nil
+}
+
+func (c *redisCluster) conn() *redis.ClusterClient {
+	return c.load().(*redis.ClusterClient)
+}
+
+//使用新配置创建go-redis客户端，apply时替换，旧客户端上的命令执行完成后关闭
+func (c *redisCluster) prepareReload(opt *Options, db int) (*pendingReload, error) {
+	if len(opt.ClusterAddress) == 0 {
+		return nil, errors.New("cannot switch from cluster to standalone without restart")
+	}
+	client, err := newRawCluster(opt)
+	if err != nil {
+		return nil, err
+	}
+	return &pendingReload{client: client, apply: func() { c.swap(client) }}, nil
+}
+
+//所有主节点，按地址排序保证多次调用顺序一致
+func (c *redisCluster) masters() ([]*redis.Client, error) {
+	var mu sync.Mutex
+	var masters []*redis.Client
+	err := c.conn().ForEachMaster(func(client *redis.Client) error {
+		mu.Lock()
+		masters = append(masters, client)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(masters, func(i, j int) bool {
+		return masters[i].Options().Addr < masters[j].Options().Addr
+	})
+	return masters, nil
+}
+
+//使用SCAN遍历所有主节点，不使用会阻塞服务端的KEYS命令
 func (c *redisCluster) GetKeys(keyLike string) ([]string, error) {
-	return c.client.Keys(keyLike).Result()
+	return c.ScanKeys(context.Background(), &ScanOptions{Match: keyLike, Count: 1000}).All()
 }
 
 func (c *redisCluster) Set(key string, value interface{}, timeout time.Duration) error {
-	if _, err := c.client.Set(key, value, timeout).Result(); err != nil {
+	if _, err := c.conn().Set(key, value, timeout).Result(); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (c *redisCluster) SetNX(key string, value interface{}, timeout time.Duration) error {
-	if _, err := c.client.SetNX(key, value, timeout).Result(); err != nil {
+	if _, err := c.conn().SetNX(key, value, timeout).Result(); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (c *redisCluster) Delete(key ...string) error {
-	if _, err := c.client.Del(key...).Result(); err != nil {
+	if _, err := c.conn().Del(key...).Result(); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (c *redisCluster) Incr(key string) (int64, error) {
-	return c.client.Incr(key).Result()
+	return c.conn().Incr(key).Result()
 }
 
 func (c *redisCluster) RPush(key string, value interface{}) error {
-	_, err := c.client.RPush(key, value).Result()
+	_, err := c.conn().RPush(key, value).Result()
 	return err
 }
 func (c *redisCluster) LPush(key string, value interface{}) error {
-	_, err := c.client.LPush(key, value).Result()
+	_, err := c.conn().LPush(key, value).Result()
 	return err
 }
 
 func (c *redisCluster) LTrim(key string, start int64, end int64) error {
-	_, err := c.client.LTrim(key, start, end).Result()
+	_, err := c.conn().LTrim(key, start, end).Result()
 	return err
 }
 
 func (c *redisCluster) Subscribe(channel string) *redis.PubSub {
-	return c.client.Subscribe(channel)
+	return c.conn().Subscribe(channel)
 }
 
 func (c *redisCluster) Publish(channel string, value interface{}) error {
-	return c.client.Publish(channel, value).Err()
+	return c.conn().Publish(channel, value).Err()
 }
 
 func (c *redisCluster) Get(key string) string {
-	value, err := c.client.Get(key).Result()
+	value, err := c.conn().Get(key).Result()
 	if nil != err {
 		return ""
 	}
@@ -71,12 +154,12 @@ func (c *redisCluster) Get(key string) string {
 }
 
 func (c *redisCluster) Ping() error {
-	_, err := c.client.Ping().Result()
+	_, err := c.conn().Ping().Result()
 	return err
 }
 
 func (c *redisCluster) Exists(key string) (bool, error) {
-	i, err := c.client.Exists(key).Result()
+	i, err := c.conn().Exists(key).Result()
 	if err != nil {
 		return false, err
 	}
@@ -84,7 +167,7 @@ func (c *redisCluster) Exists(key string) (bool, error) {
 }
 
 func (c *redisCluster) Expire(key string, duration time.Duration) (bool, error) {
-	return c.client.Expire(key, duration).Result()
+	return c.conn().Expire(key, duration).Result()
 }
 
 func (c *redisCluster) IncrAtExpire(key string, dur time.Duration) (int64, error) {
@@ -100,12 +183,12 @@ func (c *redisCluster) IncrAtExpire(key string, dur time.Duration) (int64, error
 }
 
 func (c *redisCluster) Pull(key string) ([]string, error) {
-	len, err := c.client.LLen(key).Result()
+	len, err := c.conn().LLen(key).Result()
 	if err != nil {
 		return nil, err
 	}
 	if len > 0 {
-		values, err := c.client.LRange(key, 0, len).Result()
+		values, err := c.conn().LRange(key, 0, len).Result()
 		if err != nil {
 			return nil, err
 		}
@@ -114,16 +197,16 @@ func (c *redisCluster) Pull(key string) ([]string, error) {
 	return nil, nil
 }
 func (c *redisCluster) Pop(key string) ([]string, error) {
-	len, err := c.client.LLen(key).Result()
+	len, err := c.conn().LLen(key).Result()
 	if err != nil {
 		return nil, err
 	}
 	if len > 0 {
-		values, err := c.client.LRange(key, 0, len).Result()
+		values, err := c.conn().LRange(key, 0, len).Result()
 		if err != nil {
 			return nil, err
 		}
-		result, err := c.client.Del(key).Result()
+		result, err := c.conn().Del(key).Result()
 		if err != nil {
 			return nil, err
 		}
@@ -136,7 +219,7 @@ func (c *redisCluster) Pop(key string) ([]string, error) {
 }
 
 func (c *redisCluster) SetHash(key string, field string, value interface{}) error {
-	flag, err := c.client.HSet(key, field, value).Result()
+	flag, err := c.conn().HSet(key, field, value).Result()
 	if err != nil {
 		return err
 	}
@@ -147,68 +230,70 @@ func (c *redisCluster) SetHash(key string, field string, value interface{}) erro
 	return nil
 }
 func (c *redisCluster) GetHash(key string, field string) (string, error) {
-	return c.client.HGet(key, field).Result()
+	return c.conn().HGet(key, field).Result()
 }
 
 func (c *redisCluster) FlushAll() error {
-	_, err := c.client.FlushAll().Result()
+	_, err := c.conn().FlushAll().Result()
 	return err
 }
 
 func (c *redisCluster) FlushDB() error {
-	_, err := c.client.FlushDB().Result()
+	_, err := c.conn().FlushDB().Result()
 	return err
 }
 
 func (c *redisCluster) GetHashAll(key string) (map[string]string, error) {
-	return c.client.HGetAll(key).Result()
+	return c.conn().HGetAll(key).Result()
 }
 
 func (c *redisCluster) GetHashAllMapKey(key string) ([]string, error) {
-	return c.client.HKeys(key).Result()
+	return c.conn().HKeys(key).Result()
 }
 
 func (c *redisCluster) HashDelete(key string, field string) (int64, error) {
-	return c.client.HDel(key, field).Result()
+	return c.conn().HDel(key, field).Result()
 }
 
+//按节点分组并行执行
 func (c *redisCluster) BatchSet(keys []string, value []interface{}, expire int) error {
-	p := c.client.Pipeline()
+	b := c.NewBatch()
 	expired := time.Duration(expire) * time.Second
 	for i, k := range keys {
-		p.Set(k, value[i], expired)
+		b.Set(k, value[i], expired)
 	}
 
-	_, err := p.Exec()
-	if err != nil {
-		return err
-	}
-	return nil
+	_, err := b.Exec(context.Background())
+	return err
+}
+
+func (c *redisCluster) Close() error {
+	return c.swappable.close()
 }
 
 func (c *redisCluster) GetRaw() redis.Cmdable {
-	return c.client
+	return c.conn()
 }
 
 func (c *redisCluster) ZAdd(key string, uuid string, score float64) error {
-	_, err := c.client.Do("ZADD", key, score, uuid).Result()
+	_, err := c.conn().Do("ZADD", key, score, uuid).Result()
 	return err
 }
 
 func (c *redisCluster) ZRevRank(key string, uuid string) (int64, error) {
-	return c.client.ZRevRank(key, uuid).Result()
+	return c.conn().ZRevRank(key, uuid).Result()
 }
 
 func (c *redisCluster) ZRank(key string, uuid string) (int64, error) {
-	return c.client.ZRank(key, uuid).Result()
+	return c.conn().ZRank(key, uuid).Result()
 }
 
 func (c *redisCluster) ZScore(key string, uuid string) (float64, error) {
-	return c.client.ZScore(key, uuid).Result()
+	return c.conn().ZScore(key, uuid).Result()
 }
 
 func (c *redisCluster) ZIncrBy(key string, scoreInc float64, uuid string) (float64, error) {
-	return c.client.ZIncrBy(key, scoreInc, uuid).Result()
+	return c.conn().ZIncrBy(key, scoreInc, uuid).Result()
 }
 
 func (c *redisCluster) ZRangeByScoreWithScores(key string, minScore float64, maxScore float64) ([]redis.Z, error) {
@@ -216,7 +301,7 @@ func (c *redisCluster) ZRangeByScoreWithScores(key string, minScore float64, max
 		Min: strconv.FormatFloat(minScore, 'E', -1, 64),
 		Max: strconv.FormatFloat(maxScore, 'E', -1, 64),
 	}
-	return c.client.ZRangeByScoreWithScores(key, op).Result()
+	return c.conn().ZRangeByScoreWithScores(key, op).Result()
 }
 
 func (c *redisCluster) ZRevRangeByScoreWithScores(key string, minScore float64, maxScore float64) ([]redis.Z, error) {
@@ -224,51 +309,89 @@ func (c *redisCluster) ZRevRangeByScoreWithScores(key string, minScore float64,
 		Min: strconv.FormatFloat(minScore, 'E', -1, 64),
 		Max: strconv.FormatFloat(maxScore, 'E', -1, 64),
 	}
-	return c.client.ZRevRangeByScoreWithScores(key, op).Result()
+	return c.conn().ZRevRangeByScoreWithScores(key, op).Result()
 }
 
 func (c *redisCluster) ZRangeWithScores(key string, minRank int64, maxRank int64) ([]redis.Z, error) {
-	return c.client.ZRangeWithScores(key, minRank, maxRank).Result()
+	return c.conn().ZRangeWithScores(key, minRank, maxRank).Result()
 }
 
 func (c *redisCluster) ZRevRangeWithScores(key string, minRank int64, maxRank int64) ([]redis.Z, error) {
-	return c.client.ZRevRangeWithScores(key, minRank, maxRank).Result()
+	return c.conn().ZRevRangeWithScores(key, minRank, maxRank).Result()
 }
 
 func (c *redisCluster) ZRemRangeByRank(key string, minRank int64, maxRank int64) (int64, error) {
-	return c.client.ZRemRangeByRank(key, minRank, maxRank).Result()
+	return c.conn().ZRemRangeByRank(key, minRank, maxRank).Result()
 }
 
 func (c *redisCluster) ZRem(key string, members ...interface{}) (int64, error) {
-	return c.client.ZRem(key, members).Result()
+	return c.conn().ZRem(key, members).Result()
 }
 
 func (c *redisCluster) ZRemRangeByScore(key string, min string, max string) (int64, error) {
-	return c.client.ZRemRangeByScore(key, min, max).Result()
+	return c.conn().ZRemRangeByScore(key, min, max).Result()
 }
 
 func (c *redisCluster) SetsAdd(key string, value interface{}) error {
-	_, err := c.client.SAdd(key, value).Result()
+	_, err := c.conn().SAdd(key, value).Result()
 	return err
 }
 
 func (c *redisCluster) SetsDel(key string, value interface{}) error {
-	_, err := c.client.SRem(key, value).Result()
+	_, err := c.conn().SRem(key, value).Result()
 	return err
 }
 
 func (c *redisCluster) SetsCard(key string) (int64, error) {
-	return c.client.SCard(key).Result()
+	return c.conn().SCard(key).Result()
 }
 
 func (c *redisCluster) SetsMembers(key string) ([]string, error) {
-	return c.client.SMembers(key).Result()
+	return c.conn().SMembers(key).Result()
 }
 
 func (c *redisCluster) SetsExistMember(key string, member string) (bool, error) {
-	return c.client.SIsMember(key, member).Result()
+	return c.conn().SIsMember(key, member).Result()
 }
 
+//游标高位保存主节点序号，低位为该节点的游标，依次遍历所有主节点
+//遍历期间主节点发生变化时可能遗漏或重复，需要完整遍历时使用ScanKeys
 func (c *redisCluster) Scan(cursor uint64, key string, count int64) ([]string, uint64, error) {
-	return c.client.Scan(cursor, key, count).Result()
+	masters, err := c.masters()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	node, cursor := decodeClusterCursor(cursor)
+	if node >= len(masters) {
+		return nil, 0, nil
+	}
+	keys, next, err := masters[node].Scan(cursor, key, count).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	if next == 0 {
+		node++
+		if node == len(masters) {
+			return keys, 0, nil
+		}
+	}
+	return keys, encodeClusterCursor(node, next), nil
+}
+
+//把主节点序号放入游标的高12位
+func encodeClusterCursor(node int, cursor uint64) uint64 {
+	return uint64(node)<<clusterCursorShift | cursor&clusterCursorMask
+}
+
+func decodeClusterCursor(cursor uint64) (int, uint64) {
+	return int(cursor >> clusterCursorShift), cursor & clusterCursorMask
+}
+
+func (c *redisCluster) ScanKeys(ctx context.Context, opt *ScanOptions) *KeyIterator {
+	masters, err := c.masters()
+	if err != nil {
+		return &KeyIterator{ctx: ctx, err: err}
+	}
+	return newKeyIterator(ctx, masters, true, opt)
 }
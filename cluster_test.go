@@ -0,0 +1,30 @@
+package redis_kits
+
+import "testing"
+
+func TestClusterCursor(t *testing.T) {
+	tests := []struct {
+		node   int
+		cursor uint64
+	}{
+		{0, 0},
+		{0, 17},
+		{1, 0},
+		{3, 1<<52 - 1},
+		{4095, 12345},
+	}
+	for _, tt := range tests {
+		encoded := encodeClusterCursor(tt.node, tt.cursor)
+		if encoded>>52 != uint64(tt.node) {
+			t.Errorf("node %d cursor %d: top 12 bits of %#x are %d", tt.node, tt.cursor, encoded, encoded>>52)
+		}
+		node, cursor := decodeClusterCursor(encoded)
+		if node != tt.node || cursor != tt.cursor {
+			t.Errorf("node %d cursor %d: round trip got node %d cursor %d", tt.node, tt.cursor, node, cursor)
+		}
+	}
+	//第一个节点的游标原样传给客户端
+	if encodeClusterCursor(0, 42) != 42 {
+		t.Errorf("node 0 cursor 42: got %d", encodeClusterCursor(0, 42))
+	}
+}
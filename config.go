@@ -1,59 +1,314 @@
 package redis_kits
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type config struct {
-	address string
-	port int
-	database int
-	poolSize int
-	minIdle int
-	password string
-	clusterAddress []string
+	Options
+	//REDIS_CONFIG_FILE指定的配置文件和凭据文件，热加载时检查是否修改
+	files []string
+}
+
+//Options字段对应的环境变量，用于错误提示
+var envNames = map[string]string{
+	"Address": "REDIS_ADDRESS",
+	"Port": "REDIS_PORT",
+	"Database": "REDIS_DATABASE",
+	"PoolSize": "REDIS_POOL_SIZE",
+	"MinIdle": "REDIS_MIN_IDLE",
+	"Username": "REDIS_USERNAME",
+	"Password": "REDIS_PASSWORD",
+	"ClusterAddress": "REDIS_CLUSTER_ADDRESS",
+	"SentinelAddress": "REDIS_SENTINEL_ADDRESSES",
+	"SentinelMaster": "REDIS_SENTINEL_MASTER",
+	"MaxRetries": "REDIS_MAX_RETRIES",
+	"MinRetryBackoff": "REDIS_MIN_RETRY_BACKOFF",
+	"MaxRetryBackoff": "REDIS_MAX_RETRY_BACKOFF",
+	"DialTimeout": "REDIS_DIAL_TIMEOUT",
+	"ReadTimeout": "REDIS_READ_TIMEOUT",
+	"WriteTimeout": "REDIS_WRITE_TIMEOUT",
+	"PoolTimeout": "REDIS_POOL_TIMEOUT",
+	"IdleTimeout": "REDIS_IDLE_TIMEOUT",
+	"IdleCheckFrequency": "REDIS_IDLE_CHECK_FREQUENCY",
+	"MaxConnAge": "REDIS_MAX_CONN_AGE",
+	"TLS.CAFile": "REDIS_TLS_CA_FILE",
+	"TLS.CertFile": "REDIS_TLS_CERT_FILE",
+	"TLS.KeyFile": "REDIS_TLS_KEY_FILE",
+}
+
+//parse读取的所有环境变量，配置文件中只能使用这些参数
+var configEnvNames = []string{
+	"REDIS_URL","REDIS_URL_FILE",
+	"REDIS_ADDRESS","REDIS_CLUSTER_ADDRESS","REDIS_SENTINEL_ADDRESSES","REDIS_SENTINEL_MASTER",
+	"REDIS_DATABASE","REDIS_PORT","REDIS_MIN_IDLE","REDIS_POOL_SIZE",
+	"REDIS_USERNAME","REDIS_USERNAME_FILE","REDIS_PASSWORD","REDIS_PASSWORD_FILE",
+	"REDIS_MAX_RETRIES","REDIS_MIN_RETRY_BACKOFF","REDIS_MAX_RETRY_BACKOFF",
+	"REDIS_DIAL_TIMEOUT","REDIS_READ_TIMEOUT","REDIS_WRITE_TIMEOUT","REDIS_POOL_TIMEOUT",
+	"REDIS_IDLE_TIMEOUT","REDIS_IDLE_CHECK_FREQUENCY","REDIS_MAX_CONN_AGE",
+	"REDIS_TLS_ENABLED","REDIS_TLS_CA_FILE","REDIS_TLS_CERT_FILE","REDIS_TLS_KEY_FILE",
+	"REDIS_TLS_SERVER_NAME","REDIS_TLS_INSECURE_SKIP_VERIFY",
+}
+
+//配置文件中不认识的参数，按名称排序
+func unknownDefinitionKeys(definition map[string]interface{}) []string {
+	known := make(map[string]bool,len(configEnvNames))
+	for _,name := range configEnvNames {
+		known[definitionKey(name)] = true
+	}
+	var unknown []string
+	for key := range definition {
+		if !known[key] {
+			unknown = append(unknown,key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+//使用REDIS_URL时来自URL的字段
+var urlFields = map[string]bool{
+	"Network": true,
+	"Address": true,
+	"Port": true,
+	"Database": true,
+	"PoolSize": true,
+	"MinIdle": true,
+	"ClusterAddress": true,
 }
 
 var cfg *config
 func GetConfig() (*config,error) {
+	clientMu.Lock()
+	defer clientMu.Unlock()
+	return loadConfig()
+}
+
+//调用时需持有clientMu写锁，解析失败时不保存配置，下次调用重新解析
+func loadConfig() (*config,error) {
 	if nil == cfg {
-		cfg = &config{}
-		if err := cfg.Parse(); err != nil {
+		c := &config{}
+		if err := c.Parse(); err != nil {
 			return nil,err
 		}
+		cfg = c
 	}
 
 	return cfg,nil
 }
 
+//从环境变量解析配置，所有格式和取值错误汇总在*ConfigError中返回
+//设置了REDIS_CONFIG_FILE时优先使用文件中的参数，参数名与客户端定义文件相同
 func (c *config) Parse() error {
-	var err error = nil
-	c.address = os.Getenv("REDIS_ADDRESS")
-	c.password = os.Getenv("REDIS_PASSWORD")
-	clusterAddr := os.Getenv("REDIS_CLUSTER_ADDRESS")
-	if len(clusterAddr) != 0 {
-		c.clusterAddress = strings.Split(clusterAddr,",")
+	r := &envReader{lookup: os.Getenv}
+	if file := r.string("REDIS_CONFIG_FILE"); len(file) != 0 {
+		values := make(map[string]interface{})
+		if err := decodeFile(file,&values); err != nil {
+			return err
+		}
+		c.files = append(c.files,file)
+		for _,key := range unknownDefinitionKeys(values) {
+			r.problems = append(r.problems,fmt.Sprintf("%s: unknown parameter %s",file,key))
+		}
+		fileLookup := definitionLookup(values)
+		r.lookup = func(name string) string {
+			if value := fileLookup(name); len(value) != 0 {
+				return value
+			}
+			return os.Getenv(name)
+		}
 	}
+	return c.parse(r)
+}
+
+func (c *config) parse(r *envReader) error {
+	rawurl := r.secret("REDIS_URL")
+	if len(rawurl) != 0 {
+		c.parseURL(r,rawurl)
+	} else {
+		c.Address = r.string("REDIS_ADDRESS")
+		c.ClusterAddress = r.list("REDIS_CLUSTER_ADDRESS")
+		c.SentinelAddress = r.list("REDIS_SENTINEL_ADDRESSES")
+		c.SentinelMaster = r.string("REDIS_SENTINEL_MASTER")
+		c.Database = r.int("REDIS_DATABASE",0)
+		c.Port = r.int("REDIS_PORT",0)
+		c.MinIdle = r.int("REDIS_MIN_IDLE",0)
+		c.PoolSize = r.int("REDIS_POOL_SIZE",0)
+		if r.bool("REDIS_TLS_ENABLED") {
+			c.TLS = readTLSOptions(r)
+		}
+	}
+	c.parseTuning(r)
 
-	if c.database,err = strconv.Atoi(os.Getenv("REDIS_DATABASE")); err != nil {
-		return err
+	//REDIS_USERNAME和REDIS_PASSWORD设置后覆盖REDIS_URL中的用户名和密码
+	if username := r.secret("REDIS_USERNAME"); len(username) != 0 {
+		c.Username = username
+	}
+	if password := r.secret("REDIS_PASSWORD"); len(password) != 0 {
+		c.Password = password
+	}
+	c.files = append(c.files,r.files...)
+
+	c.setDefaults()
+	problems := append(r.problems,c.validate(func(field string) string {
+		if len(rawurl) != 0 && urlFields[field] {
+			return r.name("REDIS_URL") + " " + field
+		}
+		if name,ok := envNames[field]; ok {
+			return r.name(name)
+		}
+		return field
+	})...)
+	if len(problems) != 0 {
+		return &ConfigError{Problems: problems}
 	}
-	if c.port,err = strconv.Atoi(os.Getenv("REDIS_PORT")); err != nil {
-		return err
+	return nil
+}
+
+//REDIS_URL包含了地址、数据库和连接池参数，其余地址和连接池变量不再读取
+func (c *config) parseURL(r *envReader,rawurl string) {
+	opt,err := ParseURL(rawurl)
+	if err != nil {
+		r.invalid("REDIS_URL","",err.Error())
+		return
 	}
+	c.Options = *opt
 
-	if c.minIdle,err = strconv.Atoi(os.Getenv("REDIS_MIN_IDLE")); err != nil {
-		c.minIdle = 5
+	//rediss://默认启用TLS，证书仍然从环境变量读取
+	if c.TLS != nil || r.bool("REDIS_TLS_ENABLED") {
+		c.TLS = readTLSOptions(r)
 	}
+}
 
-	if c.poolSize,err = strconv.Atoi(os.Getenv("REDIS_POOL_SIZE")); err != nil {
-		c.poolSize = 20
+//连接池、超时和重试参数，设置后覆盖REDIS_URL中的同名参数
+func (c *config) parseTuning(r *envReader) {
+	if len(r.string("REDIS_MAX_RETRIES")) != 0 {
+		c.MaxRetries = explicitRetries(r.int("REDIS_MAX_RETRIES",c.MaxRetries))
 	}
+	c.MinRetryBackoff = r.duration("REDIS_MIN_RETRY_BACKOFF",c.MinRetryBackoff)
+	c.MaxRetryBackoff = r.duration("REDIS_MAX_RETRY_BACKOFF",c.MaxRetryBackoff)
+	c.DialTimeout = r.duration("REDIS_DIAL_TIMEOUT",c.DialTimeout)
+	c.ReadTimeout = r.duration("REDIS_READ_TIMEOUT",c.ReadTimeout)
+	c.WriteTimeout = r.duration("REDIS_WRITE_TIMEOUT",c.WriteTimeout)
+	c.PoolTimeout = r.duration("REDIS_POOL_TIMEOUT",c.PoolTimeout)
+	c.IdleTimeout = r.duration("REDIS_IDLE_TIMEOUT",c.IdleTimeout)
+	c.IdleCheckFrequency = r.duration("REDIS_IDLE_CHECK_FREQUENCY",c.IdleCheckFrequency)
+	c.MaxConnAge = r.duration("REDIS_MAX_CONN_AGE",c.MaxConnAge)
+}
 
-	if c.port == 0 {
-		c.port = 6379
+func readTLSOptions(r *envReader) *TLSOptions {
+	return &TLSOptions{
+		CAFile: r.string("REDIS_TLS_CA_FILE"),
+		CertFile: r.string("REDIS_TLS_CERT_FILE"),
+		KeyFile: r.string("REDIS_TLS_KEY_FILE"),
+		ServerName: r.string("REDIS_TLS_SERVER_NAME"),
+		InsecureSkipVerify: r.bool("REDIS_TLS_INSECURE_SKIP_VERIFY"),
 	}
-	return nil
-}
\ No newline at end of file
+}
+
+//读取环境变量，格式错误记录到problems中继续解析
+//lookup和rename可以替换为其他配置来源，如配置文件中的客户端定义
+type envReader struct {
+	lookup func(name string) string
+	//错误信息中展示的参数名，为nil时使用环境变量名
+	rename func(name string) string
+	problems []string
+	//读取过的凭据文件
+	files []string
+}
+
+func (r *envReader) name(name string) string {
+	if r.rename == nil {
+		return name
+	}
+	return r.rename(name)
+}
+
+func (r *envReader) string(name string) string {
+	return strings.TrimSpace(r.lookup(name))
+}
+
+//读取凭据，设置了<name>_FILE时从文件读取，用于挂载的Secret文件
+func (r *envReader) secret(name string) string {
+	path := r.string(name + "_FILE")
+	if len(path) == 0 {
+		return r.string(name)
+	}
+	if len(r.string(name)) != 0 {
+		r.invalid(name,"",fmt.Sprintf("cannot be used together with %s",r.name(name + "_FILE")))
+	}
+
+	r.files = append(r.files,path)
+	data,err := ioutil.ReadFile(path)
+	if err != nil {
+		r.invalid(name + "_FILE",path,err.Error())
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func (r *envReader) int(name string,def int) int {
+	value := r.string(name)
+	if len(value) == 0 {
+		return def
+	}
+	i,err := strconv.Atoi(value)
+	if err != nil {
+		r.invalid(name,value,"must be an integer")
+		return def
+	}
+	return i
+}
+
+//支持time.ParseDuration格式，纯数字按毫秒处理
+func (r *envReader) duration(name string,def time.Duration) time.Duration {
+	value := r.string(name)
+	if len(value) == 0 {
+		return def
+	}
+	d,err := parseDuration(value)
+	if err != nil {
+		r.invalid(name,value,"must be a duration such as 500ms or 3s")
+		return def
+	}
+	return d
+}
+
+func (r *envReader) bool(name string) bool {
+	value := r.string(name)
+	if len(value) == 0 {
+		return false
+	}
+	b,err := strconv.ParseBool(value)
+	if err != nil {
+		r.invalid(name,value,"must be true or false")
+		return false
+	}
+	return b
+}
+
+func (r *envReader) list(name string) []string {
+	value := r.string(name)
+	if len(value) == 0 {
+		return nil
+	}
+	items := strings.Split(value,",")
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+	return items
+}
+
+func (r *envReader) invalid(name string,value string,reason string) {
+	if len(value) == 0 {
+		r.problems = append(r.problems,fmt.Sprintf("%s: %s",r.name(name),reason))
+		return
+	}
+	r.problems = append(r.problems,fmt.Sprintf("%s=%q: %s",r.name(name),value,reason))
+}
@@ -0,0 +1,180 @@
+package redis_kits
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func stubLookup(env map[string]string) func(name string) string {
+	return func(name string) string {
+		return env[name]
+	}
+}
+
+func TestParseProblems(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		problems []string
+	}{
+		{"valid", map[string]string{"REDIS_ADDRESS": "h"}, nil},
+		{"missing address", map[string]string{}, []string{"REDIS_ADDRESS: is required"}},
+		{"every invalid variable", map[string]string{
+			"REDIS_ADDRESS":      "h",
+			"REDIS_PORT":         "x",
+			"REDIS_POOL_SIZE":    "ten",
+			"REDIS_DIAL_TIMEOUT": "soon",
+			"REDIS_TLS_ENABLED":  "yes",
+		}, []string{
+			`REDIS_PORT="x": must be an integer`,
+			`REDIS_POOL_SIZE="ten": must be an integer`,
+			`REDIS_TLS_ENABLED="yes": must be true or false`,
+			`REDIS_DIAL_TIMEOUT="soon": must be a duration such as 500ms or 3s`,
+		}},
+		{"validation uses variable names", map[string]string{
+			"REDIS_ADDRESS":   "h",
+			"REDIS_POOL_SIZE": "3",
+			"REDIS_MIN_IDLE":  "4",
+		}, []string{`REDIS_MIN_IDLE="4": must not exceed pool size 3`}},
+		{"url field renames", map[string]string{
+			"REDIS_URL": "redis://h:6379?pool_size=3&min_idle=4",
+		}, []string{`REDIS_URL MinIdle="4": must not exceed pool size 3`}},
+		{"url with tuning override", map[string]string{
+			"REDIS_URL":          "redis://h:6379",
+			"REDIS_READ_TIMEOUT": "-2",
+		}, []string{`REDIS_READ_TIMEOUT="-2ms": must not be negative except -1`}},
+		{"invalid url", map[string]string{"REDIS_URL": "http://h"}, []string{"REDIS_URL: "}},
+		{"secret conflict", map[string]string{
+			"REDIS_ADDRESS":       "h",
+			"REDIS_PASSWORD":      "p",
+			"REDIS_PASSWORD_FILE": "/nonexistent/password",
+		}, []string{"REDIS_PASSWORD: cannot be used together with REDIS_PASSWORD_FILE"}},
+	}
+	for _, tt := range tests {
+		c := &config{}
+		err := c.parse(&envReader{lookup: stubLookup(tt.env)})
+		if len(tt.problems) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			continue
+		}
+		configErr, ok := err.(*ConfigError)
+		if !ok {
+			t.Errorf("%s: got %v, want *ConfigError", tt.name, err)
+			continue
+		}
+		for _, problem := range tt.problems {
+			if !hasProblem(configErr.Problems, problem) {
+				t.Errorf("%s: problems %q do not include %q", tt.name, configErr.Problems, problem)
+			}
+		}
+	}
+}
+
+//problem为完整的错误信息或其前缀
+func hasProblem(problems []string, want string) bool {
+	for _, problem := range problems {
+		if strings.HasPrefix(problem, want) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestParseSecretFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redis_kits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(path, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &config{}
+	err = c.parse(&envReader{lookup: stubLookup(map[string]string{
+		"REDIS_URL":           "redis://:inline@h:6379",
+		"REDIS_PASSWORD_FILE": path,
+	})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Password != "s3cret" {
+		t.Errorf("password: got %q, want %q", c.Password, "s3cret")
+	}
+	if !reflect.DeepEqual(c.files, []string{path}) {
+		t.Errorf("files: got %q, want %q", c.files, []string{path})
+	}
+
+	c = &config{}
+	err = c.parse(&envReader{lookup: stubLookup(map[string]string{
+		"REDIS_ADDRESS":       "h",
+		"REDIS_PASSWORD_FILE": filepath.Join(dir, "missing"),
+	})})
+	configErr, ok := err.(*ConfigError)
+	if !ok || !strings.HasPrefix(configErr.Problems[0], "REDIS_PASSWORD_FILE=") {
+		t.Errorf("missing file: got %v, want a REDIS_PASSWORD_FILE problem", err)
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	c := &config{}
+	err := c.parse(&envReader{lookup: stubLookup(map[string]string{
+		"REDIS_URL":          "redis://h:6380/2?pool_size=8",
+		"REDIS_READ_TIMEOUT": "250",
+		"REDIS_MAX_RETRIES":  "0",
+	})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Address != "h" || c.Port != 6380 || c.Database != 2 || c.PoolSize != 8 {
+		t.Errorf("url fields: got %+v", c.Options)
+	}
+	if c.ReadTimeout != 250*time.Millisecond {
+		t.Errorf("read timeout: got %v, want 250ms", c.ReadTimeout)
+	}
+	if c.maxRetries() != 0 {
+		t.Errorf("max retries: got %d, want 0", c.maxRetries())
+	}
+}
+
+func TestParseRename(t *testing.T) {
+	c := &config{}
+	err := c.parse(&envReader{
+		lookup: stubLookup(map[string]string{"REDIS_ADDRESS": "h", "REDIS_POOL_SIZE": "x"}),
+		rename: func(env string) string {
+			return "sessions." + definitionKey(env)
+		},
+	})
+	want := []string{`sessions.pool_size="x": must be an integer`}
+	if configErr, ok := err.(*ConfigError); !ok || !reflect.DeepEqual(configErr.Problems, want) {
+		t.Errorf("got %v, want %q", err, want)
+	}
+}
+
+func TestConfigEnvNames(t *testing.T) {
+	known := make(map[string]bool, len(configEnvNames))
+	for _, name := range configEnvNames {
+		known[name] = true
+	}
+	//分别走REDIS_URL和独立变量两个分支
+	for _, env := range []map[string]string{
+		{"REDIS_URL": "redis://h:6379", "REDIS_TLS_ENABLED": "true"},
+		{"REDIS_ADDRESS": "h", "REDIS_TLS_ENABLED": "true"},
+	} {
+		c := &config{}
+		c.parse(&envReader{lookup: func(name string) string {
+			if !known[name] {
+				t.Errorf("%s is read by parse but missing from configEnvNames", name)
+			}
+			return env[name]
+		}})
+	}
+}
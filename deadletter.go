@@ -0,0 +1,152 @@
+package redis_kits
+
+import (
+	"encoding/json"
+	"github.com/go-redis/redis"
+	"strconv"
+	"time"
+)
+
+//将有序集合中score不大于now的成员移动到列表，limit为0时移动所有到期的成员
+//KEYS: zset, list  ARGV: now, limit
+var moveDueScript = redis.NewScript(`
+local items = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+for _, item in ipairs(items) do
+	redis.call('ZREM', KEYS[1], item)
+	redis.call('LPUSH', KEYS[2], item)
+end
+return #items
+`)
+
+func moveDue(client RedisClient, from string, to string, now int64, limit int64) (int64, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	return moveDueScript.Run(client.GetRaw(), []string{from, to}, now, limit).Int64()
+}
+
+//从处理中列表删除消息并放入重试集合或死信列表，消息已经因超时被放回队列时返回0
+//KEYS: processing, deadlines, target  ARGV: raw, updated, score(为空时放入死信列表)
+var failScript = redis.NewScript(`
+if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 0 then
+	return 0
+end
+redis.call('ZREM', KEYS[2], ARGV[1])
+if ARGV[3] == '' then
+	redis.call('LPUSH', KEYS[3], ARGV[2])
+else
+	redis.call('ZADD', KEYS[3], ARGV[3], ARGV[2])
+end
+return 1
+`)
+
+//标记消息处理失败，未达到MaxAttempts时按指数退避放入重试集合，由Reap到期后放回队列，
+//否则连同错误信息移入死信列表，消息已经因超时被放回队列时返回false
+func (c *QueueConsumer) Fail(msg *Message, cause error) (bool, error) {
+	q := c.queue
+	envelope := messageEnvelope{
+		ID:         msg.ID,
+		Payload:    msg.Payload,
+		EnqueuedAt: msg.EnqueuedAt.UnixNano() / int64(time.Millisecond),
+		Attempts:   msg.Attempts + 1,
+	}
+	if cause != nil {
+		envelope.LastError = cause.Error()
+	}
+	updated, err := json.Marshal(envelope)
+	if err != nil {
+		return false, err
+	}
+
+	target, score := q.deadKey(), ""
+	if q.opt.MaxAttempts <= 0 || envelope.Attempts < q.opt.MaxAttempts {
+		target = q.retryKey()
+		score = strconv.FormatInt(nowMillis()+int64(q.backoff(envelope.Attempts)/time.Millisecond), 10)
+	}
+	keys := []string{q.processingKey(msg.consumer), q.deadlinesKey(), target}
+	n, err := failScript.Run(q.client.GetRaw(), keys, msg.raw, updated, score).Int64()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+//第attempts次失败后的重试间隔
+func (q *ReliableQueue) backoff(attempts int) time.Duration {
+	backoff := q.opt.RetryBackoff
+	for i := 1; i < attempts && backoff < q.opt.MaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > q.opt.MaxRetryBackoff {
+		backoff = q.opt.MaxRetryBackoff
+	}
+	return backoff
+}
+
+//等待重试的消息数量
+func (q *ReliableQueue) RetryLen() (int64, error) {
+	return q.client.GetRaw().ZCard(q.retryKey()).Result()
+}
+
+//死信列表中的消息数量
+func (q *ReliableQueue) DeadLetterLen() (int64, error) {
+	return q.client.GetRaw().LLen(q.deadKey()).Result()
+}
+
+//查看死信列表，按进入死信列表的时间从新到旧，与LRANGE的start、stop含义相同
+func (q *ReliableQueue) DeadLetters(start int64, stop int64) ([]*Message, error) {
+	items, err := q.client.GetRaw().LRange(q.deadKey(), start, stop).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*Message, 0, len(items))
+	for _, item := range items {
+		msg, err := decodeMessage(item, "")
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+//KEYS: dead, ready  ARGV: count
+var replayScript = redis.NewScript(`
+local replayed = 0
+for i = 1, tonumber(ARGV[1]) do
+	local item = redis.call('RPOP', KEYS[1])
+	if not item then
+		break
+	end
+	local message = cjson.decode(item)
+	message.attempts = nil
+	message.last_error = nil
+	redis.call('LPUSH', KEYS[2], cjson.encode(message))
+	replayed = replayed + 1
+end
+return replayed
+`)
+
+//将死信列表中最早的count条消息清除失败次数后放回队列，count不大于0时放回所有消息，返回放回的数量
+func (q *ReliableQueue) ReplayDeadLetters(count int64) (int64, error) {
+	if count <= 0 {
+		n, err := q.DeadLetterLen()
+		if err != nil || n == 0 {
+			return 0, err
+		}
+		count = n
+	}
+	return replayScript.Run(q.client.GetRaw(), []string{q.deadKey(), q.readyKey()}, count).Int64()
+}
+
+//清空死信列表，返回删除的消息数量
+func (q *ReliableQueue) PurgeDeadLetters() (int64, error) {
+	p := q.client.GetRaw().TxPipeline()
+	n := p.LLen(q.deadKey())
+	p.Del(q.deadKey())
+	if _, err := p.Exec(); err != nil {
+		return 0, err
+	}
+	return n.Val(), nil
+}
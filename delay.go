@@ -0,0 +1,137 @@
+package redis_kits
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis"
+	"time"
+)
+
+//延迟队列，消息按执行时间保存在有序集合中，由Poll将到期的消息原子地移动到就绪列表
+//队列的所有key使用相同的hash tag，可以在Cluster上使用
+type DelayQueue struct {
+	client RedisClient
+	name   string
+	keys   KeyBuilder
+}
+
+//等待执行的消息
+type ScheduledMessage struct {
+	Message
+	RunAt time.Time
+}
+
+//创建延迟队列，name作为key的hash tag，不能为空，也不能包含{和}
+func NewDelayQueue(client RedisClient, name string) (*DelayQueue, error) {
+	if err := ValidateTag(name); err != nil {
+		return nil, fmt.Errorf("delay queue name: %v", err)
+	}
+	return &DelayQueue{
+		client: client,
+		name:   name,
+		keys:   NewKeyBuilder("DELAY"),
+	}, nil
+}
+
+func (q *DelayQueue) scheduledKey() string {
+	return q.keys.Key(q.name, "scheduled")
+}
+
+func (q *DelayQueue) readyKey() string {
+	return q.keys.Key(q.name, "ready")
+}
+
+//在runAt时间放入就绪列表，返回消息ID
+func (q *DelayQueue) Schedule(payload string, runAt time.Time) (string, error) {
+	id := newID()
+	raw, err := json.Marshal(messageEnvelope{
+		ID:         id,
+		Payload:    payload,
+		EnqueuedAt: nowMillis(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	score := float64(runAt.UnixNano() / int64(time.Millisecond))
+	if err := q.client.ZAdd(q.scheduledKey(), string(raw), score); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+//延迟delay后放入就绪列表，返回消息ID
+func (q *DelayQueue) Delay(payload string, delay time.Duration) (string, error) {
+	return q.Schedule(payload, time.Now().Add(delay))
+}
+
+//将到期的消息移动到就绪列表，limit为0时移动所有到期的消息，返回移动的数量
+func (q *DelayQueue) Poll(limit int64) (int64, error) {
+	return moveDue(q.client, q.scheduledKey(), q.readyKey(), nowMillis(), limit)
+}
+
+//后台定时执行Poll，Shutdown时停止，返回停止的函数
+func (q *DelayQueue) StartPoller(interval time.Duration, onError func(err error)) (stop func()) {
+	return startTicker("delay poller "+q.name, interval, func() {
+		if _, err := q.Poll(0); err != nil && onError != nil {
+			onError(err)
+		}
+	})
+}
+
+//按执行时间顺序查看等待中的消息，count为0时返回offset之后的所有消息
+func (q *DelayQueue) Pending(offset int64, count int64) ([]*ScheduledMessage, error) {
+	if count == 0 {
+		count = -1
+	}
+	//RedisClient.ZRangeByScoreWithScores不支持LIMIT，分页需要使用go-redis的方法
+	items, err := q.client.GetRaw().ZRangeByScoreWithScores(q.scheduledKey(), redis.ZRangeBy{
+		Min:    "-inf",
+		Max:    "+inf",
+		Offset: offset,
+		Count:  count,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*ScheduledMessage, 0, len(items))
+	for _, item := range items {
+		raw, _ := item.Member.(string)
+		msg, err := decodeMessage(raw, "")
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, &ScheduledMessage{
+			Message: *msg,
+			RunAt:   time.Unix(0, int64(item.Score)*int64(time.Millisecond)),
+		})
+	}
+	return messages, nil
+}
+
+//等待中的消息数量
+func (q *DelayQueue) PendingLen() (int64, error) {
+	return q.client.GetRaw().ZCard(q.scheduledKey()).Result()
+}
+
+//就绪列表中的消息数量
+func (q *DelayQueue) Len() (int64, error) {
+	return q.client.GetRaw().LLen(q.readyKey()).Result()
+}
+
+//从就绪列表取出一条消息，timeout内没有消息时返回nil, nil，timeout为0时一直等待
+func (q *DelayQueue) Receive(timeout time.Duration) (*Message, error) {
+	//阻塞命令的超时以秒为单位
+	if timeout > 0 && timeout < time.Second {
+		timeout = time.Second
+	}
+	reply, err := q.client.GetRaw().BRPop(timeout, q.readyKey()).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeMessage(reply[1], "")
+}
@@ -0,0 +1,195 @@
+package redis_kits
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis"
+	"reflect"
+	"sync"
+	"time"
+)
+
+//事件的信封，Payload为事件结构体的JSON
+type Event struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	//发布时间
+	Time time.Time `json:"time"`
+	//链路追踪等附加信息
+	Headers map[string]string `json:"headers,omitempty"`
+	Payload json.RawMessage   `json:"payload"`
+	//收到事件的频道
+	Channel string `json:"-"`
+}
+
+//将Payload解码到v
+func (e *Event) Decode(v interface{}) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
+//自定义事件类型名称，未实现时使用包路径加类型名称
+type EventTyper interface {
+	EventType() string
+}
+
+//事件类型名称，默认为包路径加类型名称，如github.com/acme/orders.OrderCreated，不同包的同名结构体不会冲突
+//类型名称会随包路径变化，跨服务或需要重命名时实现EventTyper
+func EventTypeOf(v interface{}) string {
+	if typer, ok := v.(EventTyper); ok {
+		return typer.EventType()
+	}
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return ""
+	}
+	//内置类型和匿名类型没有包路径
+	if len(t.PkgPath()) == 0 {
+		return t.String()
+	}
+	return t.PkgPath() + "." + t.Name()
+}
+
+type EventHandler func(e *Event) error
+
+//收到没有注册处理函数的事件类型
+type UnknownEventError struct {
+	Channel string
+	Type    string
+	ID      string
+}
+
+func (e *UnknownEventError) Error() string {
+	return fmt.Sprintf("unknown event type %q on channel %s, id %s", e.Type, e.Channel, e.ID)
+}
+
+//EventBus的参数
+type EventBusOptions struct {
+	//订阅的参数，其中的OnError会被EventBusOptions.OnError覆盖
+	Subscriber SubscriberOptions
+	//收到没有注册处理函数的事件类型时回调，为空时以UnknownEventError回调OnError
+	OnUnknown func(e *Event)
+	//解码失败、处理失败和订阅连接断开时回调
+	OnError func(err error)
+}
+
+//基于Publish/Subscribe的事件总线，事件以信封的形式发布，订阅者按事件类型注册处理函数
+//  bus := NewEventBus(client, nil)
+//  bus.On("orders", EventTypeOf(OrderCreated{}), func(e *Event) error {
+//      var order OrderCreated
+//      return e.Decode(&order)
+//  })
+//  bus.Start()
+//  bus.Publish("orders", OrderCreated{ID: 1}, nil)
+type EventBus struct {
+	client     RedisClient
+	opt        EventBusOptions
+	subscriber *Subscriber
+
+	mu       sync.RWMutex
+	handlers map[string]map[string][]EventHandler
+}
+
+func NewEventBus(client RedisClient, opt *EventBusOptions) *EventBus {
+	b := &EventBus{
+		client:   client,
+		handlers: make(map[string]map[string][]EventHandler),
+	}
+	if opt != nil {
+		b.opt = *opt
+	}
+	subscriberOpt := b.opt.Subscriber
+	subscriberOpt.OnError = b.report
+	b.subscriber = NewSubscriber(client, &subscriberOpt)
+	return b
+}
+
+//发布事件，返回事件ID
+func (b *EventBus) Publish(channel string, event interface{}, headers map[string]string) (string, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+	e := Event{
+		Type:    EventTypeOf(event),
+		ID:      newID(),
+		Time:    time.Now(),
+		Headers: headers,
+		Payload: payload,
+	}
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	if err := b.client.Publish(channel, raw); err != nil {
+		return "", err
+	}
+	return e.ID, nil
+}
+
+//注册频道上某个事件类型的处理函数，同一个类型可以注册多个
+func (b *EventBus) On(channel string, eventType string, handler EventHandler) error {
+	b.mu.Lock()
+	types, exists := b.handlers[channel]
+	if !exists {
+		types = make(map[string][]EventHandler)
+		b.handlers[channel] = types
+	}
+	types[eventType] = append(types[eventType], handler)
+	b.mu.Unlock()
+
+	if exists {
+		return nil
+	}
+	return b.subscriber.Handle(channel, b.dispatch)
+}
+
+//启动订阅，Shutdown时停止
+func (b *EventBus) Start() error {
+	return b.subscriber.Start()
+}
+
+//停止订阅，等待已经收到的事件处理完成
+func (b *EventBus) Stop(ctx context.Context) error {
+	return b.subscriber.Stop(ctx)
+}
+
+//订阅的运行状态
+func (b *EventBus) Health() SubscriberHealth {
+	return b.subscriber.Health()
+}
+
+func (b *EventBus) dispatch(msg *redis.Message) error {
+	var e Event
+	if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+		return fmt.Errorf("decode event on channel %s: %v", msg.Channel, err)
+	}
+	e.Channel = msg.Channel
+
+	b.mu.RLock()
+	handlers := b.handlers[msg.Channel][e.Type]
+	b.mu.RUnlock()
+
+	if len(handlers) == 0 {
+		if b.opt.OnUnknown != nil {
+			b.opt.OnUnknown(&e)
+			return nil
+		}
+		return &UnknownEventError{Channel: e.Channel, Type: e.Type, ID: e.ID}
+	}
+	for _, handler := range handlers {
+		if err := handler(&e); err != nil {
+			b.report(err)
+		}
+	}
+	return nil
+}
+
+func (b *EventBus) report(err error) {
+	if b.opt.OnError != nil {
+		b.opt.OnError(err)
+	}
+}
@@ -0,0 +1,30 @@
+package redis_kits
+
+import "testing"
+
+type testEvent struct{}
+
+type namedEvent struct{}
+
+func (namedEvent) EventType() string {
+	return "orders.created"
+}
+
+func TestEventTypeOf(t *testing.T) {
+	tests := []struct {
+		name  string
+		event interface{}
+		want  string
+	}{
+		{"struct", testEvent{}, "github.com/penjon/jorediskits.testEvent"},
+		{"pointer", &testEvent{}, "github.com/penjon/jorediskits.testEvent"},
+		{"event typer", namedEvent{}, "orders.created"},
+		{"builtin", "text", "string"},
+		{"nil", nil, ""},
+	}
+	for _, tt := range tests {
+		if got := EventTypeOf(tt.event); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -3,7 +3,9 @@ module github.com/penjon/jorediskits
 go 1.14
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/go-redis/redis v6.15.7+incompatible
 	github.com/onsi/ginkgo v1.12.0 // indirect
 	github.com/onsi/gomega v1.10.0 // indirect
+	gopkg.in/yaml.v2 v2.3.0
 )
@@ -1,6 +1,7 @@
 package redis_kits
 
 import (
+	"context"
 	"github.com/go-redis/redis"
 	"time"
 )
@@ -20,6 +21,8 @@ type RedisClient interface {
 	Publish(string, interface{}) error
 	Get(string) string
 	Ping() error
+	//关闭连接池，关闭后客户端不可再使用
+	Close() error
 	Exists(string) (bool, error)
 	Expire(key string, duration time.Duration) (bool, error)
 	IncrAtExpire(key string, dur time.Duration) (int64, error)
@@ -29,6 +32,12 @@ type RedisClient interface {
 	GetHashAllMapKey(key string) ([]string, error)
 	HashDelete(key string, field string) (int64, error)
 	BatchSet(keys []string, value []interface{}, expire int) error
+	//批量读取，结果与keys顺序一致，Found标记key是否存在
+	BatchGet(keys []string) ([]BatchValue, error)
+	//批量写入，每个key可以设置不同的过期时间
+	BatchSetMap(entries map[string]BatchEntry) error
+	//批量执行SET、GET、HSET、EXPIRE、DEL，Cluster上按节点分组并行执行
+	NewBatch() *Batch
 	FlushAll() error
 	FlushDB() error
 	GetRaw() redis.Cmdable
@@ -50,4 +59,10 @@ type RedisClient interface {
 	SetsMembers(key string) ([]string, error)
 	SetsExistMember(key string, member string) (bool, error)
 	Scan(cursor uint64, key string, count int64) ([]string, uint64, error)
+	//遍历匹配的key，Cluster上遍历所有主节点
+	ScanKeys(ctx context.Context, opt *ScanOptions) *KeyIterator
+	//按模式分批删除，返回删除的数量
+	DeleteByPattern(ctx context.Context, pattern string, opt *BulkOptions) (int64, error)
+	//按模式分批设置过期时间，返回设置成功的数量，ttl不能小于1毫秒
+	ExpireByPattern(ctx context.Context, pattern string, ttl time.Duration, opt *BulkOptions) (int64, error)
 }
@@ -0,0 +1,211 @@
+package redis_kits
+
+import (
+	"context"
+	"github.com/go-redis/redis"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//ScanKeys的参数
+type ScanOptions struct {
+	//MATCH模式，为空时遍历所有key
+	Match string
+	//只返回指定类型的key: string、list、set、zset、hash、stream
+	Type string
+	//每批SCAN的数量，默认100
+	Count int64
+	//两批SCAN之间的间隔，降低大规模遍历对服务端的压力
+	Interval time.Duration
+}
+
+//SCAN迭代器，Cluster上依次遍历每个主节点，每个节点使用独立的游标
+//重新分片时正在迁移的slot中的key可能同时出现在源节点和目标节点上，这些key只返回一次
+//  it := client.ScanKeys(ctx, &ScanOptions{Match: "USER:*", Type: "hash"})
+//  for it.Next() {
+//      key := it.Key()
+//  }
+//  if err := it.Err(); err != nil {
+//  }
+type KeyIterator struct {
+	ctx    context.Context
+	opt    ScanOptions
+	nodes  []*redis.Client
+	node   int
+	cursor uint64
+	//已经执行过SCAN，用于控制批次间隔
+	started bool
+	//Cluster上需要对迁移中的slot去重
+	slotted bool
+	//当前节点正在迁出或迁入的slot
+	migrating map[int]struct{}
+
+	batch []string
+	key   string
+	err   error
+	//迁移中的slot里已经返回的key，只记录这部分key以限制内存
+	seen map[string]struct{}
+}
+
+func newKeyIterator(ctx context.Context, nodes []*redis.Client, slotted bool, opt *ScanOptions) *KeyIterator {
+	it := &KeyIterator{
+		ctx:     ctx,
+		nodes:   nodes,
+		slotted: slotted,
+	}
+	if opt != nil {
+		it.opt = *opt
+	}
+	if it.opt.Count <= 0 {
+		it.opt.Count = 100
+	}
+	if slotted {
+		it.seen = make(map[string]struct{})
+	}
+	return it
+}
+
+//移动到下一个key，遍历完成、出错或ctx取消时返回false
+func (it *KeyIterator) Next() bool {
+	for {
+		for len(it.batch) != 0 {
+			it.key, it.batch = it.batch[0], it.batch[1:]
+			if _, ok := it.migrating[KeySlot(it.key)]; !ok {
+				return true
+			}
+			if _, ok := it.seen[it.key]; !ok {
+				it.seen[it.key] = struct{}{}
+				return true
+			}
+		}
+
+		if it.err != nil || it.node >= len(it.nodes) {
+			return false
+		}
+		if it.err = it.wait(); it.err != nil {
+			return false
+		}
+		if it.err = it.scan(); it.err != nil {
+			return false
+		}
+	}
+}
+
+//检查ctx并等待批次间隔
+func (it *KeyIterator) wait() error {
+	if err := it.ctx.Err(); err != nil {
+		return err
+	}
+	if !it.started || it.opt.Interval <= 0 {
+		it.started = true
+		return nil
+	}
+
+	timer := time.NewTimer(it.opt.Interval)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-it.ctx.Done():
+		return it.ctx.Err()
+	}
+}
+
+func (it *KeyIterator) scan() error {
+	client := it.nodes[it.node]
+	if it.slotted && it.cursor == 0 {
+		migrating, err := migratingSlots(client)
+		if err != nil {
+			return err
+		}
+		it.migrating = migrating
+	}
+	keys, cursor, err := client.Scan(it.cursor, it.opt.Match, it.opt.Count).Result()
+	if err != nil {
+		return err
+	}
+	it.cursor = cursor
+	if cursor == 0 {
+		it.node++
+	}
+
+	if len(it.opt.Type) != 0 && len(keys) != 0 {
+		if keys, err = filterType(client, keys, it.opt.Type); err != nil {
+			return err
+		}
+	}
+	it.batch = keys
+	return nil
+}
+
+//节点正在迁出或迁入的slot，CLUSTER NODES中本节点的行里格式为[slot->-id]或[slot-<-id]
+func migratingSlots(client *redis.Client) (map[int]struct{}, error) {
+	nodes, err := client.ClusterNodes().Result()
+	if err != nil {
+		return nil, err
+	}
+	return parseMigratingSlots(nodes), nil
+}
+
+func parseMigratingSlots(nodes string) map[int]struct{} {
+	slots := make(map[int]struct{})
+	for _, line := range strings.Split(nodes, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || !strings.Contains(fields[2], "myself") {
+			continue
+		}
+		for _, field := range fields[3:] {
+			if !strings.HasPrefix(field, "[") {
+				continue
+			}
+			end := strings.IndexByte(field, '-')
+			if end < 0 {
+				continue
+			}
+			if slot, err := strconv.Atoi(field[1:end]); err == nil {
+				slots[slot] = struct{}{}
+			}
+		}
+	}
+	return slots
+}
+
+//按类型过滤，使用TYPE命令兼容不支持SCAN TYPE的Redis版本
+func filterType(client *redis.Client, keys []string, keyType string) ([]string, error) {
+	p := client.Pipeline()
+	cmds := make([]*redis.StatusCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = p.Type(key)
+	}
+	if _, err := p.Exec(); err != nil {
+		return nil, err
+	}
+
+	filtered := keys[:0]
+	for i, cmd := range cmds {
+		if cmd.Val() == keyType {
+			filtered = append(filtered, keys[i])
+		}
+	}
+	return filtered, nil
+}
+
+//当前key
+func (it *KeyIterator) Key() string {
+	return it.key
+}
+
+//遍历过程中的错误，ctx取消时为ctx.Err()
+func (it *KeyIterator) Err() error {
+	return it.err
+}
+
+//读取所有剩余的key
+func (it *KeyIterator) All() ([]string, error) {
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	return keys, it.Err()
+}
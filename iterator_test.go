@@ -0,0 +1,28 @@
+package redis_kits
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMigratingSlots(t *testing.T) {
+	const myself = "07c37dfeb235213a872192d90877d0cd55635b91 127.0.0.1:30004@31004 myself,master - 0 1426238317239 4 connected 0-5460"
+	const other = "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 127.0.0.1:30001@31001 master - 0 1426238316232 1 connected 5461-10922 [5461->-07c37dfeb235213a872192d90877d0cd55635b91]"
+	tests := []struct {
+		name  string
+		nodes string
+		want  map[int]struct{}
+	}{
+		{"stable", myself + "\n" + other + "\n", map[int]struct{}{}},
+		{"migrating", myself + " [93->-292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f]\n" + other + "\n", map[int]struct{}{93: {}}},
+		{"importing", myself + " [5461-<-e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca]\n" + other + "\n", map[int]struct{}{5461: {}}},
+		{"both", myself + " [93->-292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f] [5461-<-e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca]", map[int]struct{}{93: {}, 5461: {}}},
+		{"other node only", other + "\n" + myself + "\n", map[int]struct{}{}},
+		{"empty", "", map[int]struct{}{}},
+	}
+	for _, tt := range tests {
+		if got := parseMigratingSlots(tt.nodes); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,432 @@
+package redis_kits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//常用的key事件
+const (
+	KeyEventExpired = "expired"
+	KeyEventEvicted = "evicted"
+	KeyEventDel     = "del"
+	KeyEventExpire  = "expire"
+	KeyEventSet     = "set"
+)
+
+//事件对应的notify-keyspace-events类型，未列出的事件使用A
+var keyEventFlags = map[string]string{
+	KeyEventExpired: "x",
+	KeyEventEvicted: "e",
+	KeyEventDel:     "g",
+	KeyEventExpire:  "g",
+	"rename_from":   "g",
+	"rename_to":     "g",
+	"new":           "n",
+	KeyEventSet:     "$",
+	"setrange":      "$",
+	"incrby":        "$",
+	"incrbyfloat":   "$",
+	"append":        "$",
+	"lpush":         "l",
+	"rpush":         "l",
+	"lpop":          "l",
+	"rpop":          "l",
+	"hset":          "h",
+	"hdel":          "h",
+	"hincrby":       "h",
+	"sadd":          "s",
+	"srem":          "s",
+	"zadd":          "z",
+	"zrem":          "z",
+	"xadd":          "t",
+}
+
+//收到的key事件
+type KeyEvent struct {
+	//事件名称，如expired、evicted、del
+	Event string
+	Key   string
+	DB    int
+	//产生事件的节点地址，Sentinel模式下为master名称
+	Node string
+}
+
+type KeyEventHandler func(event KeyEvent)
+
+//KeyspaceListener的参数
+type KeyspaceOptions struct {
+	//订阅的事件，默认只订阅expired
+	Events []string
+	//只回调匹配的key，与KEYS的模式语法相同，为空时回调所有key
+	Match string
+	//启动时在每个节点上执行CONFIG SET notify-keyspace-events，保留节点上已有的设置
+	Enable bool
+	//连接断开后重新订阅的间隔，默认1秒
+	ReconnectDelay time.Duration
+	//没有事件时发送PING检查连接的间隔，默认30秒
+	PingInterval time.Duration
+	//开启通知失败和连接断开时回调
+	OnError func(err error)
+}
+
+//key事件监听，订阅__keyevent@<db>__:<event>频道，Cluster上订阅每个主节点
+//任意节点连接断开时重新获取节点并订阅，回调可能在不同节点的协程中并发执行
+type KeyspaceListener struct {
+	client  RedisClient
+	handler KeyEventHandler
+	opt     KeyspaceOptions
+
+	mu       sync.Mutex
+	started  bool
+	worker   *worker
+	done     chan struct{}
+	finished chan struct{}
+	stopOnce sync.Once
+}
+
+//订阅key事件的节点
+type keyspaceNode struct {
+	client *redis.Client
+	//配置的数据库，配置了Username时与client.Options().DB不同
+	db   int
+	addr string
+}
+
+//可以获取所有主节点的客户端
+type nodesClient interface {
+	keyspaceNodes() ([]keyspaceNode, error)
+}
+
+func (c *redisStandard) keyspaceNodes() ([]keyspaceNode, error) {
+	target := c.target.Load().(standardTarget)
+	return []keyspaceNode{{client: c.conn(), db: target.db, addr: target.addr}}, nil
+}
+
+func (c *redisCluster) keyspaceNodes() ([]keyspaceNode, error) {
+	masters, err := c.masters()
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]keyspaceNode, len(masters))
+	for i, master := range masters {
+		nodes[i] = keyspaceNode{client: master, addr: master.Options().Addr}
+	}
+	return nodes, nil
+}
+
+func NewKeyspaceListener(client RedisClient, handler KeyEventHandler, opt *KeyspaceOptions) *KeyspaceListener {
+	l := &KeyspaceListener{
+		client:   client,
+		handler:  handler,
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	if opt != nil {
+		l.opt = *opt
+	}
+	if len(l.opt.Events) == 0 {
+		l.opt.Events = []string{KeyEventExpired}
+	}
+	if l.opt.ReconnectDelay <= 0 {
+		l.opt.ReconnectDelay = time.Second
+	}
+	if l.opt.PingInterval <= 0 {
+		l.opt.PingInterval = 30 * time.Second
+	}
+	return l
+}
+
+//启动监听，Shutdown时停止
+func (l *KeyspaceListener) Start() error {
+	client, ok := l.client.(nodesClient)
+	if !ok {
+		return errors.New("client does not support keyspace listener")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.started {
+		return errors.New("keyspace listener already started")
+	}
+	l.started = true
+	go l.run(client)
+	l.worker = registerWorker("keyspace listener", l.shutdown)
+	return nil
+}
+
+//停止监听，超过ctx期限时返回ctx的错误
+func (l *KeyspaceListener) Stop(ctx context.Context) error {
+	l.mu.Lock()
+	w := l.worker
+	started := l.started
+	l.mu.Unlock()
+	if w != nil {
+		w.unregister()
+	}
+	if !started {
+		return nil
+	}
+	return l.shutdown(ctx)
+}
+
+func (l *KeyspaceListener) shutdown(ctx context.Context) error {
+	l.stopOnce.Do(func() { close(l.done) })
+	return waitDone(ctx, l.finished)
+}
+
+func (l *KeyspaceListener) run(client nodesClient) {
+	defer close(l.finished)
+	for {
+		err := l.listen(client)
+		select {
+		case <-l.done:
+			return
+		default:
+		}
+		l.report(err)
+
+		timer := time.NewTimer(l.opt.ReconnectDelay)
+		select {
+		case <-l.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+//订阅所有主节点，任意节点出错或停止时关闭所有订阅并返回
+func (l *KeyspaceListener) listen(client nodesClient) error {
+	nodes, err := client.keyspaceNodes()
+	if err != nil {
+		return err
+	}
+	if l.opt.Enable {
+		flags := notifyFlags(l.opt.Events)
+		for _, node := range nodes {
+			if err := enableNotifications(node.client, flags); err != nil {
+				return fmt.Errorf("enable keyspace notifications on %s: %v", node.addr, err)
+			}
+		}
+	}
+
+	errs := make(chan error, len(nodes))
+	var wg sync.WaitGroup
+	pubsubs := make([]*redis.PubSub, 0, len(nodes))
+	for _, node := range nodes {
+		channels := make([]string, len(l.opt.Events))
+		for i, event := range l.opt.Events {
+			channels[i] = "__keyevent@" + strconv.Itoa(node.db) + "__:" + event
+		}
+		pubsub := node.client.PSubscribe(channels...)
+		pubsubs = append(pubsubs, pubsub)
+
+		wg.Add(1)
+		go func(pubsub *redis.PubSub, addr string) {
+			defer wg.Done()
+			errs <- l.receive(pubsub, addr)
+		}(pubsub, node.addr)
+	}
+
+	select {
+	case err = <-errs:
+	case <-l.done:
+	}
+	for _, pubsub := range pubsubs {
+		pubsub.Close()
+	}
+	wg.Wait()
+	return err
+}
+
+func (l *KeyspaceListener) receive(pubsub *redis.PubSub, addr string) error {
+	for {
+		received, err := pubsub.ReceiveTimeout(l.opt.PingInterval)
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				if err := pubsub.Ping(); err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+
+		msg, ok := received.(*redis.Message)
+		if !ok {
+			continue
+		}
+		event, ok := parseKeyEvent(msg, addr)
+		if !ok || (len(l.opt.Match) != 0 && !globMatch(l.opt.Match, event.Key)) {
+			continue
+		}
+		l.handler(event)
+	}
+}
+
+//频道格式: __keyevent@<db>__:<event>，消息内容为key
+func parseKeyEvent(msg *redis.Message, addr string) (KeyEvent, bool) {
+	rest := strings.TrimPrefix(msg.Channel, "__keyevent@")
+	i := strings.Index(rest, "__:")
+	if len(rest) == len(msg.Channel) || i < 0 {
+		return KeyEvent{}, false
+	}
+	db, err := strconv.Atoi(rest[:i])
+	if err != nil {
+		return KeyEvent{}, false
+	}
+	return KeyEvent{Event: rest[i+3:], Key: msg.Payload, DB: db, Node: addr}, true
+}
+
+//订阅的事件需要的notify-keyspace-events设置
+func notifyFlags(events []string) string {
+	flags := "E"
+	for _, event := range events {
+		flag, ok := keyEventFlags[event]
+		if !ok {
+			flag = "A"
+		}
+		if !strings.Contains(flags, flag) {
+			flags += flag
+		}
+	}
+	return flags
+}
+
+//在节点已有的设置上增加flags
+func enableNotifications(node *redis.Client, flags string) error {
+	current, err := node.ConfigGet("notify-keyspace-events").Result()
+	if err != nil {
+		return err
+	}
+	existing := ""
+	if len(current) == 2 {
+		existing, _ = current[1].(string)
+	}
+
+	merged := mergeNotifyFlags(existing, flags)
+	if merged == existing {
+		return nil
+	}
+	return node.ConfigSet("notify-keyspace-events", merged).Err()
+}
+
+//在existing后追加缺少的flags，existing中的A已包含g$lshzxet
+func mergeNotifyFlags(existing string, flags string) string {
+	merged := existing
+	for _, flag := range flags {
+		if !strings.ContainsRune(merged, flag) && !(strings.ContainsRune(merged, 'A') && strings.ContainsRune("g$lshzxet", flag)) {
+			merged += string(flag)
+		}
+	}
+	return merged
+}
+
+//与Redis的KEYS模式相同的匹配规则，支持*、?、[abc]、[^a]、[a-z]和\转义
+func globMatch(pattern string, s string) bool {
+	for len(pattern) != 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := classEnd(pattern[1:])
+			if end < 0 {
+				//没有闭合时按普通字符匹配
+				if s[0] != '[' {
+					return false
+				}
+				pattern, s = pattern[1:], s[1:]
+				continue
+			}
+			if !matchClass(pattern[1:end+1], s[0]) {
+				return false
+			}
+			pattern, s = pattern[end+2:], s[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+//[]中字符集合的结束位置，跳过\转义的]，没有闭合时返回-1
+func classEnd(class string) int {
+	for i := 0; i < len(class); i++ {
+		if class[i] == '\\' {
+			i++
+		} else if class[i] == ']' {
+			return i
+		}
+	}
+	return -1
+}
+
+//匹配[]中的字符集合
+func matchClass(class string, c byte) bool {
+	negate := len(class) != 0 && class[0] == '^'
+	if negate {
+		class = class[1:]
+	}
+	matched := false
+	for i := 0; i < len(class); i++ {
+		if class[i] == '\\' && i+1 < len(class) {
+			i++
+			if class[i] == c {
+				matched = true
+			}
+		} else if i+2 < len(class) && class[i+1] == '-' {
+			lo, hi := class[i], class[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				matched = true
+			}
+			i += 2
+		} else if class[i] == c {
+			matched = true
+		}
+	}
+	return matched != negate
+}
+
+func (l *KeyspaceListener) report(err error) {
+	if err != nil && l.opt.OnError != nil {
+		l.opt.OnError(err)
+	}
+}
@@ -0,0 +1,136 @@
+package redis_kits
+
+import (
+	"testing"
+
+	"github.com/go-redis/redis"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"*", "", true},
+		{"*", "session:1", true},
+		{"session:*", "session:1", true},
+		{"session:*", "user:1", false},
+		{"*:lock", "order:1:lock", true},
+		{"a**b", "axxb", true},
+		{"a*b*c", "abxc", true},
+		{"a*b", "ac", false},
+		{"?", "a", true},
+		{"?", "", false},
+		{"user:?", "user:12", false},
+		{"h?llo", "hello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{"h[c-a]llo", "hbllo", true},
+		{"h[\\]]llo", "h]llo", true},
+		{"\\*", "*", true},
+		{"\\*", "a", false},
+		{"a\\?", "a?", true},
+		{"a\\?", "ab", false},
+		{"a\\", "a\\", true},
+		{"[abc", "[abc", true},
+		{"[abc", "abc", false},
+		{"[a", "", false},
+		{"a", "ab", false},
+	}
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestMatchClass(t *testing.T) {
+	tests := []struct {
+		class string
+		c     byte
+		want  bool
+	}{
+		{"abc", 'b', true},
+		{"abc", 'd', false},
+		{"^a", 'a', false},
+		{"^a", 'b', true},
+		{"a-z", 'm', true},
+		{"a-z", 'M', false},
+		{"^a-z", 'M', true},
+		{"0-9_", '_', true},
+		{"\\-", '-', true},
+		{"\\^", '^', true},
+		{"a-", '-', true},
+		{"", 'a', false},
+	}
+	for _, tt := range tests {
+		if got := matchClass(tt.class, tt.c); got != tt.want {
+			t.Errorf("matchClass(%q, %q) = %v, want %v", tt.class, tt.c, got, tt.want)
+		}
+	}
+}
+
+func TestParseKeyEvent(t *testing.T) {
+	tests := []struct {
+		channel string
+		want    KeyEvent
+		ok      bool
+	}{
+		{"__keyevent@0__:expired", KeyEvent{Event: "expired", Key: "k", DB: 0, Node: "n"}, true},
+		{"__keyevent@12__:rename_to", KeyEvent{Event: "rename_to", Key: "k", DB: 12, Node: "n"}, true},
+		{"__keyspace@0__:k", KeyEvent{}, false},
+		{"__keyevent@x__:del", KeyEvent{}, false},
+		{"__keyevent@0:del", KeyEvent{}, false},
+		{"expired", KeyEvent{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseKeyEvent(&redis.Message{Channel: tt.channel, Payload: "k"}, "n")
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseKeyEvent(%q) = %+v, %v, want %+v, %v", tt.channel, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNotifyFlags(t *testing.T) {
+	tests := []struct {
+		events []string
+		want   string
+	}{
+		{nil, "E"},
+		{[]string{KeyEventExpired}, "Ex"},
+		{[]string{KeyEventDel, KeyEventExpire, KeyEventExpired}, "Egx"},
+		{[]string{"unknown"}, "EA"},
+	}
+	for _, tt := range tests {
+		if got := notifyFlags(tt.events); got != tt.want {
+			t.Errorf("notifyFlags(%q) = %q, want %q", tt.events, got, tt.want)
+		}
+	}
+}
+
+func TestMergeNotifyFlags(t *testing.T) {
+	tests := []struct {
+		existing string
+		flags    string
+		want     string
+	}{
+		{"", "Ex", "Ex"},
+		{"Ex", "Ex", "Ex"},
+		{"Ex", "Eg", "Exg"},
+		{"AE", "Egx", "AE"},
+		{"AK", "Ex", "AKE"},
+		{"AE", "En", "AEn"},
+		{"K", "Ex", "KEx"},
+		{"Kx", "Ex", "KxE"},
+	}
+	for _, tt := range tests {
+		if got := mergeNotifyFlags(tt.existing, tt.flags); got != tt.want {
+			t.Errorf("mergeNotifyFlags(%q, %q) = %q, want %q", tt.existing, tt.flags, got, tt.want)
+		}
+	}
+}
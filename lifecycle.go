@@ -0,0 +1,102 @@
+package redis_kits
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+//后台任务，如订阅、热加载和Sentinel监听，Shutdown时统一停止
+type worker struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+var (
+	workersMu sync.Mutex
+	workers   = make(map[*worker]struct{})
+)
+
+//注册后台任务，任务自行结束时调用unregister注销
+func registerWorker(name string, stop func(ctx context.Context) error) *worker {
+	w := &worker{name: name, stop: stop}
+	workersMu.Lock()
+	workers[w] = struct{}{}
+	workersMu.Unlock()
+	return w
+}
+
+func (w *worker) unregister() {
+	workersMu.Lock()
+	delete(workers, w)
+	workersMu.Unlock()
+}
+
+//等待后台任务结束，超过ctx期限时返回ctx的错误
+func waitDone(ctx context.Context, done <-chan struct{}) error {
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//停止所有后台任务并关闭GetClient、GetClientByIndex和默认Registry的客户端
+//之后再调用GetClient会重新读取配置创建客户端，返回第一个错误
+func Shutdown(ctx context.Context) error {
+	var first error
+	record := func(err error) {
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+
+	workersMu.Lock()
+	stopping := make([]*worker, 0, len(workers))
+	for w := range workers {
+		stopping = append(stopping, w)
+	}
+	workers = make(map[*worker]struct{})
+	workersMu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(stopping))
+	for i, w := range stopping {
+		wg.Add(1)
+		go func(i int, w *worker) {
+			defer wg.Done()
+			if err := w.stop(ctx); err != nil {
+				errs[i] = fmt.Errorf("stop %s: %v", w.name, err)
+			}
+		}(i, w)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		record(err)
+	}
+
+	clientMu.Lock()
+	if c != nil {
+		record(c.Close())
+		c = nil
+	}
+	for index, client := range clients {
+		record(client.Close())
+		delete(clients, index)
+	}
+	cfg = nil
+	clientMu.Unlock()
+
+	record(CloseClients())
+	return first
+}
+
+//测试使用，关闭所有客户端和后台任务并清除注册的回调，恢复到未初始化的状态
+func Reset() {
+	Shutdown(context.Background())
+	sentinelMu.Lock()
+	sentinelHandlers = nil
+	sentinelErrorHandlers = nil
+	sentinelMu.Unlock()
+}
@@ -6,25 +6,39 @@ import (
 	"time"
 )
 
+var lockKeys = NewKeyBuilder("LOCK:ID")
+
+//锁的key，锁名称作为hash tag，不能为空，也不能包含{和}
+//同时返回不带hash tag的旧格式key，滚动升级期间新旧版本都能看到对方的锁
+func getLockKeys(lockName string) ([]string,error) {
+	if err := ValidateTag(lockName); err != nil {
+		return nil,err
+	}
+	return []string{lockKeys.Key(lockName),fmt.Sprintf("LOCK:ID:%s",lockName)},nil
+}
+
 //检查锁是否存在
 func ExistLock(lockName string) (bool,error) {
 	client,err := GetClient()
 	if err != nil {
 		return false,err
 	}
-	key := fmt.Sprintf("LOCK:ID:%s",lockName)
-	return client.Exists(key)
+	keys,err := getLockKeys(lockName)
+	if err != nil {
+		return false,err
+	}
+	for _,key := range keys {
+		exists,err := client.Exists(key)
+		if err != nil || exists {
+			return exists,err
+		}
+	}
+	return false,nil
 }
 
 //获取Redis锁
 func GetLock(lockName string,timeout time.Duration) error {
-	client,err := GetClient()
-	if err != nil {
-		return err
-	}
-
-	key := fmt.Sprintf("LOCK:ID:%s",lockName)
-	exists,err := client.Exists(key)
+	exists,err := ExistLock(lockName)
 	if err != nil {
 		return err
 	}
@@ -32,9 +46,19 @@ func GetLock(lockName string,timeout time.Duration) error {
 		return errors.New("Lock exists")
 	}
 
-	if err := client.SetNX(key,"",timeout); err != nil {
+	client,err := GetClient()
+	if err != nil {
 		return err
 	}
+	keys,err := getLockKeys(lockName)
+	if err != nil {
+		return err
+	}
+	for _,key := range keys {
+		if err := client.SetNX(key,"",timeout); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -44,9 +68,14 @@ func ReleaseLock(lockName string) error {
 	if err != nil {
 		return err
 	}
-	key := fmt.Sprintf("LOCK:ID:%s",lockName)
+	keys,err := getLockKeys(lockName)
 	if err != nil {
 		return err
 	}
-	return client.Delete(key)
-}
\ No newline at end of file
+	for _,key := range keys {
+		if err := client.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
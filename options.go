@@ -0,0 +1,293 @@
+package redis_kits
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Redis连接参数，GetClient从环境变量解析，也可以直接构造后调用NewClient
+type Options struct {
+	//tcp或unix，默认tcp
+	Network         string
+	Address         string
+	Port            int
+	Database        int
+	PoolSize        int
+	MinIdle         int
+	//Redis 6 ACL用户名，为空时只使用密码认证
+	Username        string
+	Password        string
+	ClusterAddress  []string
+	SentinelAddress []string
+	SentinelMaster  string
+	//为nil时不启用TLS
+	TLS *TLSOptions
+
+	//命令失败重试次数，为0时使用默认值3，-1不重试，环境变量和URL中设置为0同样表示不重试
+	MaxRetries      int
+	MinRetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+	//以下超时为0时使用go-redis默认值，Read/WriteTimeout为-1时不超时
+	DialTimeout        time.Duration
+	ReadTimeout        time.Duration
+	WriteTimeout       time.Duration
+	PoolTimeout        time.Duration
+	IdleTimeout        time.Duration
+	IdleCheckFrequency time.Duration
+	MaxConnAge         time.Duration
+}
+
+//TLS连接参数，同时配置CertFile和KeyFile时启用双向认证
+type TLSOptions struct {
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+func (o *TLSOptions) tlsConfig() (*tls.Config, error) {
+	if o == nil {
+		return nil, nil
+	}
+
+	t := &tls.Config{
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+
+	if len(o.CAFile) != 0 {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read TLS CA file: %v", err)
+		}
+		t.RootCAs = x509.NewCertPool()
+		if !t.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in TLS CA file %s", o.CAFile)
+		}
+	}
+
+	if len(o.CertFile) != 0 || len(o.KeyFile) != 0 {
+		if len(o.CertFile) == 0 || len(o.KeyFile) == 0 {
+			return nil, errors.New("TLS client certificate requires both cert file and key file")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load TLS client certificate: %v", err)
+		}
+		t.Certificates = []tls.Certificate{cert}
+	}
+	return t, nil
+}
+
+func (o *Options) addr() string {
+	if o.Network == "unix" {
+		return o.Address
+	}
+	//IPv6地址需要加方括号
+	return net.JoinHostPort(o.Address, strconv.Itoa(o.Port))
+}
+
+//认证参数，go-redis v6不支持ACL用户名，配置了Username时不使用go-redis的密码认证，
+//在OnConnect中执行AUTH username password后再切换数据库
+func (o *Options) auth(db int) (password string, database int, onConnect func(*redis.Conn) error) {
+	if len(o.Username) == 0 {
+		return o.Password, db, nil
+	}
+
+	//不能复用命名返回值password，return ""会同时清空闭包中的密码
+	username, secret := o.Username, o.Password
+	return "", 0, func(conn *redis.Conn) error {
+		if err := conn.Process(redis.NewStatusCmd("AUTH", username, secret)); err != nil {
+			return err
+		}
+		if db > 0 {
+			return conn.Select(db).Err()
+		}
+		return nil
+	}
+}
+
+//go-redis使用0表示不重试，不支持-1
+func (o *Options) maxRetries() int {
+	if o.MaxRetries < 0 {
+		return 0
+	}
+	return o.MaxRetries
+}
+
+//环境变量和URL中显式设置的0表示不重试，转换为-1以区别于未设置
+func explicitRetries(n int) int {
+	if n == 0 {
+		return -1
+	}
+	return n
+}
+
+//根据参数创建客户端，配置了ClusterAddress时创建Cluster客户端
+func NewClient(opt *Options) (RedisClient, error) {
+	if err := opt.Validate(); err != nil {
+		return nil, err
+	}
+
+	if len(opt.ClusterAddress) != 0 {
+		client, err := newClusterClient(opt)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+
+	client, err := newStandardClient(opt, opt.Database)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+//配置错误，包含所有校验失败的参数
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid redis config: " + strings.Join(e.Problems, "; ")
+}
+
+//填充默认值: Network=tcp, Port=6379, PoolSize=20, MinIdle=5(不超过PoolSize), MaxRetries=3
+func (o *Options) setDefaults() {
+	if o.MaxRetries == 0 {
+		o.MaxRetries = 3
+	}
+	if len(o.Network) == 0 {
+		o.Network = "tcp"
+	}
+	if o.Port == 0 {
+		o.Port = 6379
+	}
+	if o.PoolSize == 0 {
+		o.PoolSize = 20
+	}
+	//未设置MinIdle时不超过连接池大小，避免只设置了较小的PoolSize时校验失败
+	if o.MinIdle == 0 {
+		o.MinIdle = 5
+		if o.PoolSize > 0 && o.PoolSize < o.MinIdle {
+			o.MinIdle = o.PoolSize
+		}
+	}
+}
+
+//填充默认值后校验参数，错误信息中使用Options的字段名
+func (o *Options) Validate() error {
+	o.setDefaults()
+	if problems := o.validate(func(field string) string { return field }); len(problems) != 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+//校验参数，name将字段名转换为错误信息中展示的参数名
+func (o *Options) validate(name func(field string) string) []string {
+	var problems []string
+	invalid := func(field string, value interface{}, reason string) {
+		problems = append(problems, fmt.Sprintf("%s=%q: %s", name(field), fmt.Sprint(value), reason))
+	}
+	missing := func(field string, reason string) {
+		problems = append(problems, fmt.Sprintf("%s: %s", name(field), reason))
+	}
+
+	cluster := len(o.ClusterAddress) != 0
+	sentinel := len(o.SentinelAddress) != 0
+	switch {
+	case cluster && sentinel:
+		missing("SentinelAddress", "cannot be used together with cluster address")
+	case cluster:
+		validateHosts(o.ClusterAddress, func(addr string) { invalid("ClusterAddress", addr, "must be host:port") })
+		if o.Database != 0 {
+			invalid("Database", o.Database, "cluster only supports database 0")
+		}
+	case sentinel:
+		validateHosts(o.SentinelAddress, func(addr string) { invalid("SentinelAddress", addr, "must be host:port") })
+		if len(o.SentinelMaster) == 0 {
+			missing("SentinelMaster", "is required when sentinel address is set")
+		}
+	default:
+		if len(o.Address) == 0 {
+			missing("Address", "is required")
+		}
+		if o.Network != "tcp" && o.Network != "unix" {
+			invalid("Network", o.Network, "must be tcp or unix")
+		}
+		if o.Network == "tcp" && (o.Port < 1 || o.Port > 65535) {
+			invalid("Port", o.Port, "must be between 1 and 65535")
+		}
+	}
+
+	if o.Database < 0 {
+		invalid("Database", o.Database, "must not be negative")
+	}
+	if o.PoolSize < 0 {
+		invalid("PoolSize", o.PoolSize, "must not be negative")
+	}
+	if o.MinIdle < 0 {
+		invalid("MinIdle", o.MinIdle, "must not be negative")
+	} else if o.MinIdle > o.PoolSize && o.PoolSize > 0 {
+		invalid("MinIdle", o.MinIdle, fmt.Sprintf("must not exceed pool size %d", o.PoolSize))
+	}
+
+	if len(o.Username) != 0 && len(o.Password) == 0 {
+		missing("Password", "is required when username is set")
+	}
+	if o.MaxRetries < -1 {
+		invalid("MaxRetries", o.MaxRetries, "must be -1 or greater")
+	}
+	if o.MinRetryBackoff > 0 && o.MaxRetryBackoff > 0 && o.MinRetryBackoff > o.MaxRetryBackoff {
+		invalid("MinRetryBackoff", o.MinRetryBackoff, fmt.Sprintf("must not exceed max retry backoff %s", o.MaxRetryBackoff))
+	}
+	for field, value := range map[string]time.Duration{
+		"DialTimeout":        o.DialTimeout,
+		"PoolTimeout":        o.PoolTimeout,
+		"IdleCheckFrequency": o.IdleCheckFrequency,
+		"MaxConnAge":         o.MaxConnAge,
+	} {
+		if value < 0 {
+			invalid(field, value, "must not be negative")
+		}
+	}
+	//-1表示不超时，其他负数会让go-redis使用已经过去的截止时间
+	for field, value := range map[string]time.Duration{
+		"ReadTimeout":  o.ReadTimeout,
+		"WriteTimeout": o.WriteTimeout,
+		"IdleTimeout":  o.IdleTimeout,
+	} {
+		if value < 0 && value != -1 {
+			invalid(field, value, "must not be negative except -1")
+		}
+	}
+
+	if o.TLS != nil && (len(o.TLS.CertFile) == 0) != (len(o.TLS.KeyFile) == 0) {
+		if len(o.TLS.CertFile) == 0 {
+			missing("TLS.CertFile", "is required when TLS key file is set")
+		} else {
+			missing("TLS.KeyFile", "is required when TLS cert file is set")
+		}
+	}
+	return problems
+}
+
+func validateHosts(addrs []string, invalid func(addr string)) {
+	for _, addr := range addrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || len(host) == 0 || len(port) == 0 {
+			invalid(addr)
+		}
+	}
+}
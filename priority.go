@@ -0,0 +1,217 @@
+package redis_kits
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis"
+	"strconv"
+	"time"
+)
+
+const (
+	//同一优先级内按入队时间排序的score跨度，约317年的毫秒数
+	priorityScale = 1e13
+	//score需要小于2^53才能精确表示毫秒，超过后相同优先级不再先进先出
+	maxPriorityLimit = 899
+	//队列为空时Pop轮询的间隔
+	priorityPollInterval = 100 * time.Millisecond
+)
+
+//优先级队列，消息ID保存在有序集合中，score由优先级和入队时间组成，优先级高的先出队，相同优先级先进先出
+//消息内容保存在hash中，按ID删除时同时删除内容
+//队列的所有key使用相同的hash tag，可以在Cluster上使用
+type PriorityQueue struct {
+	client RedisClient
+	name   string
+	//最高优先级，优先级范围为0到maxPriority
+	maxPriority int
+	keys        KeyBuilder
+}
+
+//优先级队列中的消息
+type PriorityMessage struct {
+	Message
+	Priority int
+}
+
+type priorityEnvelope struct {
+	messageEnvelope
+	Priority int `json:"priority"`
+}
+
+//创建优先级队列，优先级范围为0到maxPriority，数值越大越先出队，maxPriority最大为899
+//name作为key的hash tag，不能为空，也不能包含{和}
+func NewPriorityQueue(client RedisClient, name string, maxPriority int) (*PriorityQueue, error) {
+	if err := ValidateTag(name); err != nil {
+		return nil, fmt.Errorf("priority queue name: %v", err)
+	}
+	if maxPriority < 0 || maxPriority > maxPriorityLimit {
+		return nil, fmt.Errorf("max priority %d out of range [0, %d]", maxPriority, maxPriorityLimit)
+	}
+	return &PriorityQueue{
+		client:      client,
+		name:        name,
+		maxPriority: maxPriority,
+		keys:        NewKeyBuilder("PRIORITY"),
+	}, nil
+}
+
+func (q *PriorityQueue) itemsKey() string {
+	return q.keys.Key(q.name, "items")
+}
+
+func (q *PriorityQueue) payloadsKey() string {
+	return q.keys.Key(q.name, "payloads")
+}
+
+//优先级对应的score下限
+func (q *PriorityQueue) baseScore(priority int) float64 {
+	return float64(q.maxPriority-priority) * priorityScale
+}
+
+//消息入队，返回消息ID
+func (q *PriorityQueue) Push(payload string, priority int) (string, error) {
+	if priority < 0 || priority > q.maxPriority {
+		return "", fmt.Errorf("priority %d out of range [0, %d]", priority, q.maxPriority)
+	}
+
+	now := nowMillis()
+	id := newID()
+	raw, err := json.Marshal(priorityEnvelope{
+		messageEnvelope: messageEnvelope{ID: id, Payload: payload, EnqueuedAt: now},
+		Priority:        priority,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	p := q.client.GetRaw().TxPipeline()
+	p.HSet(q.payloadsKey(), id, raw)
+	p.ZAdd(q.itemsKey(), redis.Z{Score: q.baseScore(priority) + float64(now), Member: id})
+	if _, err := p.Exec(); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+//取出score最小的消息ID并读取、删除消息内容，队列为空时返回nil
+//KEYS: items, payloads
+var popScript = redis.NewScript(`
+while true do
+	local popped = redis.call('ZPOPMIN', KEYS[1])
+	if #popped == 0 then
+		return false
+	end
+	local payload = redis.call('HGET', KEYS[2], popped[1])
+	if payload then
+		redis.call('HDEL', KEYS[2], popped[1])
+		return payload
+	end
+end
+`)
+
+//取出优先级最高的消息，timeout内没有消息时返回nil, nil，timeout为0时一直等待，timeout不能为负数
+//ctx取消或超时时返回ctx.Err()
+//出队和读取内容在一个Lua脚本中原子执行，队列为空时每100毫秒轮询一次
+func (q *PriorityQueue) Pop(ctx context.Context, timeout time.Duration) (*PriorityMessage, error) {
+	if timeout < 0 {
+		return nil, fmt.Errorf("priority queue pop timeout must not be negative, got %v", timeout)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		raw, err := popScript.Run(q.client.GetRaw(), []string{q.itemsKey(), q.payloadsKey()}).String()
+		if err == nil {
+			return decodePriorityMessage(raw)
+		}
+		if err != redis.Nil {
+			return nil, err
+		}
+
+		wait := priorityPollInterval
+		if timeout > 0 {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return nil, nil
+			}
+			if remaining < wait {
+				wait = remaining
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func decodePriorityMessage(raw string) (*PriorityMessage, error) {
+	var envelope priorityEnvelope
+	if err := json.Unmarshal([]byte(raw), &envelope); err != nil {
+		return nil, err
+	}
+	return &PriorityMessage{
+		Message: Message{
+			ID:         envelope.ID,
+			Payload:    envelope.Payload,
+			EnqueuedAt: time.Unix(0, envelope.EnqueuedAt*int64(time.Millisecond)),
+			raw:        raw,
+		},
+		Priority: envelope.Priority,
+	}, nil
+}
+
+//KEYS: items, payloads
+var peekScript = redis.NewScript(`
+local id = redis.call('ZRANGE', KEYS[1], 0, 0)[1]
+if not id then
+	return false
+end
+return redis.call('HGET', KEYS[2], id)
+`)
+
+//查看优先级最高的消息但不取出，队列为空时返回nil, nil
+func (q *PriorityQueue) Peek() (*PriorityMessage, error) {
+	raw, err := peekScript.Run(q.client.GetRaw(), []string{q.itemsKey(), q.payloadsKey()}).String()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodePriorityMessage(raw)
+}
+
+//队列中的消息总数
+func (q *PriorityQueue) Len() (int64, error) {
+	return q.client.GetRaw().ZCard(q.itemsKey()).Result()
+}
+
+//指定优先级的消息数量
+func (q *PriorityQueue) LenByPriority(priority int) (int64, error) {
+	min := strconv.FormatFloat(q.baseScore(priority), 'f', 0, 64)
+	max := "(" + strconv.FormatFloat(q.baseScore(priority)+priorityScale, 'f', 0, 64)
+	return q.client.GetRaw().ZCount(q.itemsKey(), min, max).Result()
+}
+
+//KEYS: items, payloads  ARGV: id
+var removeScript = redis.NewScript(`
+local removed = redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+return removed
+`)
+
+//按ID删除消息，消息不存在或已经出队时返回false
+func (q *PriorityQueue) Remove(id string) (bool, error) {
+	n, err := removeScript.Run(q.client.GetRaw(), []string{q.itemsKey(), q.payloadsKey()}, id).Int64()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
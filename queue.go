@@ -0,0 +1,300 @@
+package redis_kits
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//可靠队列的参数
+type QueueOptions struct {
+	//消息取出后未确认的超时时间，超时后重新放回队列，默认30秒
+	VisibilityTimeout time.Duration
+	//最大投递次数，Fail或超时达到次数后移入死信列表，为0时不限制
+	MaxAttempts int
+	//Fail后重试的初始间隔，每次失败翻倍，默认1秒
+	RetryBackoff time.Duration
+	//重试间隔的上限，默认5分钟
+	MaxRetryBackoff time.Duration
+}
+
+//至少一次投递的可靠队列
+//消费者取出消息时原子地移动到自己的处理中列表，确认后删除，
+//超过可见性超时仍未确认的消息由Reap放回队列
+//队列的所有key使用相同的hash tag，可以在Cluster上使用
+type ReliableQueue struct {
+	client RedisClient
+	name   string
+	opt    QueueOptions
+	keys   KeyBuilder
+}
+
+//队列中的消息
+type Message struct {
+	ID      string
+	Payload string
+	//入队时间
+	EnqueuedAt time.Time
+	//已经失败的次数，包括Fail和可见性超时
+	Attempts int
+	//最后一次失败的原因
+	LastError string
+
+	//消息在列表中的原始内容，确认时按原始内容删除
+	raw string
+	//取出消息的消费者
+	consumer string
+}
+
+type messageEnvelope struct {
+	ID         string `json:"id"`
+	Payload    string `json:"payload"`
+	EnqueuedAt int64  `json:"enqueued_at"`
+	Attempts   int    `json:"attempts,omitempty"`
+	LastError  string `json:"last_error,omitempty"`
+}
+
+//创建可靠队列，name作为key的hash tag，不能为空，也不能包含{和}
+func NewReliableQueue(client RedisClient, name string, opt *QueueOptions) (*ReliableQueue, error) {
+	if err := ValidateTag(name); err != nil {
+		return nil, fmt.Errorf("queue name: %v", err)
+	}
+	q := &ReliableQueue{
+		client: client,
+		name:   name,
+		keys:   NewKeyBuilder("QUEUE"),
+	}
+	if opt != nil {
+		q.opt = *opt
+	}
+	if q.opt.VisibilityTimeout <= 0 {
+		q.opt.VisibilityTimeout = 30 * time.Second
+	}
+	if q.opt.RetryBackoff <= 0 {
+		q.opt.RetryBackoff = time.Second
+	}
+	if q.opt.MaxRetryBackoff <= 0 {
+		q.opt.MaxRetryBackoff = 5 * time.Minute
+	}
+	return q, nil
+}
+
+func (q *ReliableQueue) readyKey() string {
+	return q.keys.Key(q.name, "ready")
+}
+
+func (q *ReliableQueue) processingKey(consumer string) string {
+	return q.keys.Key(q.name, "processing", consumer)
+}
+
+func (q *ReliableQueue) deadlinesKey() string {
+	return q.keys.Key(q.name, "deadlines")
+}
+
+func (q *ReliableQueue) consumersKey() string {
+	return q.keys.Key(q.name, "consumers")
+}
+
+func (q *ReliableQueue) retryKey() string {
+	return q.keys.Key(q.name, "retry")
+}
+
+func (q *ReliableQueue) deadKey() string {
+	return q.keys.Key(q.name, "dead")
+}
+
+//生成随机ID
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+//消息入队，返回消息ID
+func (q *ReliableQueue) Push(payload string) (string, error) {
+	id := newID()
+	raw, err := json.Marshal(messageEnvelope{
+		ID:         id,
+		Payload:    payload,
+		EnqueuedAt: nowMillis(),
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := q.client.GetRaw().LPush(q.readyKey(), raw).Err(); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+//等待消费的消息数量
+func (q *ReliableQueue) Len() (int64, error) {
+	return q.client.GetRaw().LLen(q.readyKey()).Result()
+}
+
+//获取消费者，消费者名称在同一个队列中唯一，进程重启后使用相同的名称可以继续处理未确认的消息
+func (q *ReliableQueue) Consumer(name string) (*QueueConsumer, error) {
+	if err := q.client.GetRaw().SAdd(q.consumersKey(), name).Err(); err != nil {
+		return nil, err
+	}
+	return &QueueConsumer{queue: q, name: name}, nil
+}
+
+//队列消费者
+type QueueConsumer struct {
+	queue *ReliableQueue
+	name  string
+}
+
+//取出一条消息并移动到处理中列表，timeout内没有消息时返回nil, nil，timeout为0时一直等待
+func (c *QueueConsumer) Receive(timeout time.Duration) (*Message, error) {
+	q := c.queue
+	//阻塞命令的超时以秒为单位
+	if timeout > 0 && timeout < time.Second {
+		timeout = time.Second
+	}
+	raw, err := q.client.GetRaw().BRPopLPush(q.readyKey(), q.processingKey(c.name), timeout).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	//记录可见性超时，进程在此之前退出时由Reap补充
+	deadline := nowMillis() + int64(q.opt.VisibilityTimeout/time.Millisecond)
+	if err := q.client.GetRaw().ZAdd(q.deadlinesKey(), redis.Z{Score: float64(deadline), Member: raw}).Err(); err != nil {
+		return nil, err
+	}
+	return decodeMessage(raw, c.name)
+}
+
+func decodeMessage(raw string, consumer string) (*Message, error) {
+	var envelope messageEnvelope
+	if err := json.Unmarshal([]byte(raw), &envelope); err != nil {
+		return nil, err
+	}
+	return &Message{
+		ID:         envelope.ID,
+		Payload:    envelope.Payload,
+		EnqueuedAt: time.Unix(0, envelope.EnqueuedAt*int64(time.Millisecond)),
+		Attempts:   envelope.Attempts,
+		LastError:  envelope.LastError,
+		raw:        raw,
+		consumer:   consumer,
+	}, nil
+}
+
+var ackScript = redis.NewScript(`
+local removed = redis.call('LREM', KEYS[1], 1, ARGV[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+return removed
+`)
+
+//确认消息处理完成，消息已经因超时被放回队列时返回false
+func (c *QueueConsumer) Ack(msg *Message) (bool, error) {
+	q := c.queue
+	n, err := ackScript.Run(q.client.GetRaw(), []string{q.processingKey(msg.consumer), q.deadlinesKey()}, msg.raw).Int64()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+//超时的消息增加失败次数，达到最大次数时移入死信列表
+//KEYS: processing, deadlines, ready, dead  ARGV: now, visibility timeout, max attempts
+var reapScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local max = tonumber(ARGV[3])
+local requeued = 0
+for _, item in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+	local deadline = redis.call('ZSCORE', KEYS[2], item)
+	if not deadline then
+		redis.call('ZADD', KEYS[2], now + tonumber(ARGV[2]), item)
+	elseif tonumber(deadline) <= now then
+		redis.call('LREM', KEYS[1], 1, item)
+		redis.call('ZREM', KEYS[2], item)
+		local message = cjson.decode(item)
+		message.attempts = (message.attempts or 0) + 1
+		message.last_error = 'visibility timeout'
+		if max > 0 and message.attempts >= max then
+			redis.call('LPUSH', KEYS[4], cjson.encode(message))
+		else
+			redis.call('RPUSH', KEYS[3], cjson.encode(message))
+			requeued = requeued + 1
+		end
+	end
+end
+return requeued
+`)
+
+//将所有消费者中超过可见性超时的消息放回队列头部，并将到期的重试消息放回队列，返回放回的数量
+func (q *ReliableQueue) Reap() (int64, error) {
+	consumers, err := q.client.GetRaw().SMembers(q.consumersKey()).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	visibility := strconv.FormatInt(int64(q.opt.VisibilityTimeout/time.Millisecond), 10)
+	for _, consumer := range consumers {
+		keys := []string{q.processingKey(consumer), q.deadlinesKey(), q.readyKey(), q.deadKey()}
+		n, err := reapScript.Run(q.client.GetRaw(), keys, nowMillis(), visibility, q.opt.MaxAttempts).Int64()
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+
+	n, err := moveDue(q.client, q.retryKey(), q.readyKey(), nowMillis(), 0)
+	return total + n, err
+}
+
+//后台定时执行Reap，Shutdown时停止，返回停止的函数
+func (q *ReliableQueue) StartReaper(interval time.Duration, onError func(err error)) (stop func()) {
+	return startTicker("queue reaper "+q.name, interval, func() {
+		if _, err := q.Reap(); err != nil && onError != nil {
+			onError(err)
+		}
+	})
+}
+
+//启动定时执行的后台任务，注册到Shutdown
+func startTicker(name string, interval time.Duration, fn func()) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+
+	var once sync.Once
+	shutdown := func(ctx context.Context) error {
+		once.Do(func() { close(done) })
+		return waitDone(ctx, finished)
+	}
+	w := registerWorker(name, shutdown)
+	return func() {
+		w.unregister()
+		shutdown(context.Background())
+	}
+}
@@ -0,0 +1,299 @@
+package redis_kits
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//按名称管理多个客户端，客户端定义从配置文件加载
+//配置文件中每个客户端的参数名与环境变量相同，去掉REDIS_前缀并使用小写，如:
+//clients:
+//  sessions:
+//    url: redis://:password@10.0.0.1:6379/1
+//  cache:
+//    cluster_address: [10.0.0.2:6379, 10.0.0.3:6379]
+//    pool_size: 50
+type Registry struct {
+	mu      sync.RWMutex
+	path    string
+	//客户端定义中引用的凭据文件
+	files   []string
+	clients map[string]RedisClient
+}
+
+type registryFile struct {
+	Clients map[string]map[string]interface{} `json:"clients" yaml:"clients" toml:"clients"`
+}
+
+//加载配置文件创建所有客户端，支持.yaml/.yml/.json/.toml
+//任意一个客户端配置错误时返回*ConfigError，不会创建任何客户端
+func LoadRegistry(path string) (*Registry, error) {
+	options, files, err := readRegistryFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Registry{path: path, files: files, clients: make(map[string]RedisClient, len(options))}
+	for name, opt := range options {
+		client, err := NewClient(opt)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("redis client %s: %v", name, err)
+		}
+		r.clients[name] = client
+	}
+	return r, nil
+}
+
+//重新读取配置文件，替换已有客户端的底层连接，创建新增的客户端并关闭已删除的客户端
+//所有客户端都创建成功后才替换，任意一个失败时保留原来的客户端和配置
+func (r *Registry) Reload() error {
+	commit, err := r.prepareReload()
+	if err != nil {
+		return err
+	}
+	commit()
+	return nil
+}
+
+//创建所有新的客户端，返回替换的函数，调用前一直持有r.mu
+func (r *Registry) prepareReload() (commit func(), err error) {
+	options, files, err := readRegistryFile(r.path)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	var pending pendingReloads
+	added := make(map[string]RedisClient)
+	discard := func() {
+		pending.discard()
+		for _, client := range added {
+			client.Close()
+		}
+		r.mu.Unlock()
+	}
+	for name, opt := range options {
+		if client, ok := r.clients[name]; ok {
+			p, err := client.(reloader).prepareReload(opt, opt.Database)
+			if err != nil {
+				discard()
+				return nil, fmt.Errorf("redis client %s: %v", name, err)
+			}
+			pending = append(pending, p)
+			continue
+		}
+		client, err := NewClient(opt)
+		if err != nil {
+			discard()
+			return nil, fmt.Errorf("redis client %s: %v", name, err)
+		}
+		added[name] = client
+	}
+
+	return func() {
+		defer r.mu.Unlock()
+		pending.apply()
+		for name, client := range added {
+			r.clients[name] = client
+		}
+		for name, client := range r.clients {
+			if _, ok := options[name]; !ok {
+				client.Close()
+				delete(r.clients, name)
+			}
+		}
+		r.files = files
+	}, nil
+}
+
+//读取并校验配置文件中的所有客户端定义
+func readRegistryFile(path string) (map[string]*Options, []string, error) {
+	var file registryFile
+	if err := decodeFile(path, &file); err != nil {
+		return nil, nil, err
+	}
+
+	var problems, files []string
+	options := make(map[string]*Options, len(file.Clients))
+	for _, name := range sortedNames(file.Clients) {
+		c := &config{}
+		definition := file.Clients[name]
+		for _, key := range unknownDefinitionKeys(definition) {
+			problems = append(problems, name+"."+key+": unknown parameter")
+		}
+		err := c.parse(&envReader{
+			lookup: definitionLookup(definition),
+			rename: func(env string) string {
+				return name + "." + definitionKey(env)
+			},
+		})
+		if err != nil {
+			problems = append(problems, err.(*ConfigError).Problems...)
+			continue
+		}
+		options[name] = &c.Options
+		files = append(files, c.files...)
+	}
+	if len(problems) != 0 {
+		return nil, nil, &ConfigError{Problems: problems}
+	}
+	return options, files, nil
+}
+
+//按扩展名解析YAML、JSON或TOML文件
+func decodeFile(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, v)
+	case ".json":
+		err = json.Unmarshal(data, v)
+	case ".toml":
+		err = toml.Unmarshal(data, v)
+	default:
+		return fmt.Errorf("unsupported redis config file %s, expect .yaml, .yml, .json or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parse redis config file %s: %v", path, err)
+	}
+	return nil
+}
+
+//环境变量名在配置文件中对应的参数名，REDIS_POOL_SIZE对应pool_size
+func definitionKey(env string) string {
+	return strings.ToLower(strings.TrimPrefix(env, "REDIS_"))
+}
+
+//按环境变量名读取配置文件中的参数，列表转换为逗号分隔的字符串
+func definitionLookup(definition map[string]interface{}) func(env string) string {
+	return func(env string) string {
+		switch value := definition[definitionKey(env)].(type) {
+		case nil:
+			return ""
+		case []interface{}:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = definitionString(item)
+			}
+			return strings.Join(items, ",")
+		default:
+			return definitionString(value)
+		}
+	}
+}
+
+//JSON中的数字解码为float64，fmt.Sprint会把较大的数字格式化为1e+06
+func definitionString(value interface{}) string {
+	if f, ok := value.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+func sortedNames(definitions map[string]map[string]interface{}) []string {
+	names := make([]string, 0, len(definitions))
+	for name := range definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//按名称获取客户端
+func (r *Registry) Client(name string) (RedisClient, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	client, ok := r.clients[name]
+	if !ok {
+		return nil, fmt.Errorf("redis client %s is not defined", name)
+	}
+	return client, nil
+}
+
+//客户端名称列表
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.clients))
+	for name := range r.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//关闭所有客户端，返回第一个关闭错误
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var first error
+	for name, client := range r.clients {
+		if err := client.Close(); err != nil && first == nil {
+			first = fmt.Errorf("close redis client %s: %v", name, err)
+		}
+		delete(r.clients, name)
+	}
+	return first
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   *Registry
+)
+
+func defaultRegistry() *Registry {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return registry
+}
+
+//加载配置文件作为默认Registry，之后通过Client(name)获取客户端
+func LoadClients(path string) error {
+	r, err := LoadRegistry(path)
+	if err != nil {
+		return err
+	}
+
+	registryMu.Lock()
+	prev := registry
+	registry = r
+	registryMu.Unlock()
+	if prev != nil {
+		prev.Close()
+	}
+	return nil
+}
+
+//从默认Registry获取客户端
+func Client(name string) (RedisClient, error) {
+	r := defaultRegistry()
+	if r == nil {
+		return nil, fmt.Errorf("redis client %s is not defined, call LoadClients first", name)
+	}
+	return r.Client(name)
+}
+
+//关闭默认Registry中的所有客户端
+func CloseClients() error {
+	registryMu.Lock()
+	r := registry
+	registry = nil
+	registryMu.Unlock()
+	if r == nil {
+		return nil
+	}
+	return r.Close()
+}
@@ -0,0 +1,231 @@
+package redis_kits
+
+import (
+	"context"
+	"github.com/go-redis/redis"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	//热加载后旧客户端等待命令执行完成的最长时间
+	drainTimeout  = 30 * time.Second
+	drainInterval = 100 * time.Millisecond
+)
+
+type rawClient interface {
+	WrapProcess(fn func(oldProcess func(redis.Cmder) error) func(redis.Cmder) error)
+	WrapProcessPipeline(fn func(oldProcess func([]redis.Cmder) error) func([]redis.Cmder) error)
+	Close() error
+}
+
+//go-redis客户端及其正在执行的命令数
+type trackedClient struct {
+	client   rawClient
+	inflight int64
+}
+
+//可以原子替换的go-redis客户端，RedisClient的实现通过它访问底层连接
+type swappable struct {
+	current atomic.Value
+}
+
+type reloader interface {
+	prepareReload(opt *Options, db int) (*pendingReload, error)
+}
+
+//已经创建但还未替换的go-redis客户端，所有客户端都创建成功后才替换，任意一个失败时全部关闭
+type pendingReload struct {
+	client rawClient
+	apply  func()
+}
+
+type pendingReloads []*pendingReload
+
+func (p pendingReloads) apply() {
+	for _, pending := range p {
+		pending.apply()
+	}
+}
+
+func (p pendingReloads) discard() {
+	for _, pending := range p {
+		pending.client.Close()
+	}
+}
+
+func (s *swappable) load() rawClient {
+	return s.current.Load().(*trackedClient).client
+}
+
+//替换底层客户端，旧客户端在命令执行完成后关闭
+func (s *swappable) swap(client rawClient) {
+	t := &trackedClient{client: client}
+	client.WrapProcess(func(process func(redis.Cmder) error) func(redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			atomic.AddInt64(&t.inflight, 1)
+			defer atomic.AddInt64(&t.inflight, -1)
+			return process(cmd)
+		}
+	})
+	client.WrapProcessPipeline(func(process func([]redis.Cmder) error) func([]redis.Cmder) error {
+		return func(cmds []redis.Cmder) error {
+			atomic.AddInt64(&t.inflight, 1)
+			defer atomic.AddInt64(&t.inflight, -1)
+			return process(cmds)
+		}
+	})
+
+	prev, _ := s.current.Load().(*trackedClient)
+	s.current.Store(t)
+	if prev != nil {
+		go prev.drain()
+	}
+}
+
+func (s *swappable) close() error {
+	return s.current.Load().(*trackedClient).client.Close()
+}
+
+func (t *trackedClient) drain() {
+	deadline := time.Now().Add(drainTimeout)
+	for {
+		//至少等待一个间隔，让已经取到旧客户端但还未发出的命令开始执行
+		time.Sleep(drainInterval)
+		if atomic.LoadInt64(&t.inflight) == 0 || time.Now().After(deadline) {
+			break
+		}
+	}
+	t.client.Close()
+}
+
+var reloadMu sync.Mutex
+
+//重新读取配置，替换GetClient、GetClientByIndex和默认Registry中客户端的底层连接
+//已经获取的RedisClient无需重新获取，所有新连接都创建成功后才替换，任意一个失败时保留所有原连接
+func Reload() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	next := &config{}
+	if err := next.Parse(); err != nil {
+		return err
+	}
+
+	clientMu.Lock()
+	defer clientMu.Unlock()
+	var pending pendingReloads
+	if r, ok := c.(reloader); ok {
+		p, err := r.prepareReload(&next.Options, next.Database)
+		if err != nil {
+			return err
+		}
+		pending = append(pending, p)
+	}
+	for index, client := range clients {
+		p, err := client.(reloader).prepareReload(&next.Options, index)
+		if err != nil {
+			pending.discard()
+			return err
+		}
+		pending = append(pending, p)
+	}
+
+	if r := defaultRegistry(); r != nil {
+		commit, err := r.prepareReload()
+		if err != nil {
+			pending.discard()
+			return err
+		}
+		defer commit()
+	}
+	pending.apply()
+	cfg = next
+	return nil
+}
+
+//热加载需要关注的配置文件
+func watchedFiles() []string {
+	var files []string
+	clientMu.RLock()
+	if cfg != nil {
+		files = append(files, cfg.files...)
+	}
+	clientMu.RUnlock()
+
+	if r := defaultRegistry(); r != nil {
+		r.mu.RLock()
+		files = append(files, r.path)
+		files = append(files, r.files...)
+		r.mu.RUnlock()
+	}
+	return files
+}
+
+//收到SIGHUP或配置文件、凭据文件修改时调用Reload，interval为检查文件修改的间隔
+//onError接收热加载失败的错误，返回停止监听的函数
+func WatchReload(interval time.Duration, onError func(err error)) (stop func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	reload := func() {
+		if err := Reload(); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		defer signal.Stop(hup)
+
+		modified := fileVersions(watchedFiles())
+		for {
+			select {
+			case <-done:
+				return
+			case <-hup:
+				reload()
+			case <-ticker.C:
+				current := fileVersions(watchedFiles())
+				if current != modified {
+					modified = current
+					reload()
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	shutdown := func(ctx context.Context) error {
+		once.Do(func() { close(done) })
+		return waitDone(ctx, finished)
+	}
+	w := registerWorker("reload watcher", shutdown)
+	return func() {
+		w.unregister()
+		shutdown(context.Background())
+	}
+}
+
+//文件修改时间和大小拼接的版本号
+func fileVersions(files []string) string {
+	version := ""
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			version += file + ":missing;"
+			continue
+		}
+		version += file + ":" + info.ModTime().String() + ":" + strconv.FormatInt(info.Size(), 10) + ";"
+	}
+	return version
+}
@@ -0,0 +1,305 @@
+package redis_kits
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis"
+	"strings"
+	"sync"
+	"time"
+)
+
+var rpcKeys = NewKeyBuilder("RPC")
+
+//服务端处理请求的函数，返回值编码为JSON返回给调用方
+type RPCHandler func(ctx context.Context, params json.RawMessage) (interface{}, error)
+
+//服务端处理失败或没有对应的方法时返回给调用方的错误
+type RPCError struct {
+	Method  string
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc %s: %s", e.Method, e.Message)
+}
+
+type rpcRequest struct {
+	ID      string `json:"id"`
+	Method  string `json:"method"`
+	ReplyTo string `json:"reply_to"`
+	//调用方的截止时间，毫秒时间戳，为0时没有截止时间
+	Deadline int64           `json:"deadline,omitempty"`
+	Params   json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	ID     string          `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+func rpcRequestsKey(service string) string {
+	return rpcKeys.Key(service, "requests")
+}
+
+//RPC调用方，请求放入服务的请求列表，在各自的回复key上等待结果
+type RPCClient struct {
+	client  RedisClient
+	service string
+}
+
+//service作为key的hash tag，不能为空，也不能包含{和}
+func NewRPCClient(client RedisClient, service string) (*RPCClient, error) {
+	if err := ValidateTag(service); err != nil {
+		return nil, fmt.Errorf("rpc service: %v", err)
+	}
+	return &RPCClient{client: client, service: service}, nil
+}
+
+//调用服务的方法，params编码为JSON，结果解码到result，result为nil时忽略结果
+//ctx没有截止时间时一直等待，ctx结束后最多1秒内返回ctx的错误
+func (c *RPCClient) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	id := newID()
+	request := rpcRequest{
+		ID:      id,
+		Method:  method,
+		ReplyTo: rpcKeys.Key(c.service, "reply", id),
+		Params:  encoded,
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		request.Deadline = deadline.UnixNano() / int64(time.Millisecond)
+	}
+	raw, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	if err := c.client.GetRaw().LPush(rpcRequestsKey(c.service), raw).Err(); err != nil {
+		return err
+	}
+
+	reply, err := c.wait(ctx, request.ReplyTo)
+	if err != nil {
+		//超时后回复可能仍然到达，由回复的过期时间清理
+		return err
+	}
+	var response rpcResponse
+	if err := json.Unmarshal([]byte(reply), &response); err != nil {
+		return err
+	}
+	if len(response.Error) != 0 {
+		return &RPCError{Method: method, Message: response.Error}
+	}
+	if result == nil || len(response.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
+//循环BLPOP等待回复，每次最多阻塞1秒以便检查ctx
+func (c *RPCClient) wait(ctx context.Context, key string) (string, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		reply, err := c.client.GetRaw().BLPop(time.Second, key).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return reply[1], nil
+	}
+}
+
+//RPCServer的参数
+type RPCServerOptions struct {
+	//处理请求的协程数量，默认1
+	Workers int
+	//回复key的过期时间，调用方超时后未读取的回复由过期清理，默认1分钟，不能小于1毫秒
+	ReplyTTL time.Duration
+	//读取请求和发送回复失败时回调
+	OnError func(err error)
+}
+
+//RPC服务端，按方法名注册处理函数
+//  server, err := NewRPCServer(client, "billing", nil)
+//  server.Handle("charge", func(ctx context.Context, params json.RawMessage) (interface{}, error) { ... })
+//  server.Start()
+//  defer server.Stop(ctx)
+type RPCServer struct {
+	client  RedisClient
+	service string
+	opt     RPCServerOptions
+
+	mu       sync.RWMutex
+	handlers map[string]RPCHandler
+	started  bool
+	worker   *worker
+
+	done     chan struct{}
+	workers  sync.WaitGroup
+	stopOnce sync.Once
+}
+
+//service作为key的hash tag，不能为空，也不能包含{和}
+func NewRPCServer(client RedisClient, service string, opt *RPCServerOptions) (*RPCServer, error) {
+	if err := ValidateTag(service); err != nil {
+		return nil, fmt.Errorf("rpc service: %v", err)
+	}
+	s := &RPCServer{
+		client:   client,
+		service:  service,
+		handlers: make(map[string]RPCHandler),
+		done:     make(chan struct{}),
+	}
+	if opt != nil {
+		s.opt = *opt
+	}
+	if s.opt.Workers <= 0 {
+		s.opt.Workers = 1
+	}
+	if s.opt.ReplyTTL <= 0 {
+		s.opt.ReplyTTL = time.Minute
+	}
+	if err := checkExpireTTL(s.opt.ReplyTTL); err != nil {
+		return nil, fmt.Errorf("rpc reply ttl: %v", err)
+	}
+	return s, nil
+}
+
+//注册方法的处理函数
+func (s *RPCServer) Handle(method string, handler RPCHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[method] = handler
+}
+
+//启动处理协程，Shutdown时停止
+func (s *RPCServer) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return errors.New("rpc server already started")
+	}
+	s.started = true
+
+	for i := 0; i < s.opt.Workers; i++ {
+		s.workers.Add(1)
+		go s.work()
+	}
+	s.worker = registerWorker("rpc server "+s.service, s.shutdown)
+	return nil
+}
+
+//停止接收请求，等待正在处理的请求完成，超过ctx期限时返回ctx的错误
+func (s *RPCServer) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	w := s.worker
+	s.mu.Unlock()
+	if w != nil {
+		w.unregister()
+	}
+	return s.shutdown(ctx)
+}
+
+func (s *RPCServer) shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.done) })
+	finished := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(finished)
+	}()
+	return waitDone(ctx, finished)
+}
+
+func (s *RPCServer) work() {
+	defer s.workers.Done()
+	for {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		//每次最多阻塞1秒以便检查是否停止
+		reply, err := s.client.GetRaw().BRPop(time.Second, rpcRequestsKey(s.service)).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			s.report(err)
+			timer := time.NewTimer(time.Second)
+			select {
+			case <-s.done:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			continue
+		}
+		s.serve(reply[1])
+	}
+}
+
+func (s *RPCServer) serve(raw string) {
+	var request rpcRequest
+	if err := json.Unmarshal([]byte(raw), &request); err != nil {
+		s.report(fmt.Errorf("decode rpc request: %v", err))
+		return
+	}
+	//只回复到本服务的回复key，避免请求中的ReplyTo写入任意key
+	if !strings.HasPrefix(request.ReplyTo, rpcKeys.Key(s.service, "reply")+":") {
+		s.report(fmt.Errorf("rpc request %s: invalid reply key %q", request.ID, request.ReplyTo))
+		return
+	}
+
+	ctx := context.Background()
+	if request.Deadline != 0 {
+		deadline := time.Unix(0, request.Deadline*int64(time.Millisecond))
+		//调用方已经超时，不再处理
+		if time.Now().After(deadline) {
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	response := rpcResponse{ID: request.ID}
+	s.mu.RLock()
+	handler, ok := s.handlers[request.Method]
+	s.mu.RUnlock()
+	if !ok {
+		response.Error = "unknown method"
+	} else if result, err := handler(ctx, request.Params); err != nil {
+		response.Error = err.Error()
+	} else if response.Result, err = json.Marshal(result); err != nil {
+		response.Error = err.Error()
+	}
+
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		s.report(err)
+		return
+	}
+	p := s.client.GetRaw().TxPipeline()
+	p.RPush(request.ReplyTo, encoded)
+	p.PExpire(request.ReplyTo, s.opt.ReplyTTL)
+	if _, err := p.Exec(); err != nil {
+		s.report(err)
+	}
+}
+
+func (s *RPCServer) report(err error) {
+	if s.opt.OnError != nil {
+		s.opt.OnError(err)
+	}
+}
@@ -0,0 +1,208 @@
+package redis_kits
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/go-redis/redis"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Sentinel主节点切换事件
+type MasterSwitchEvent struct {
+	MasterName string
+	OldAddress string
+	NewAddress string
+}
+
+type MasterSwitchHandler func(event MasterSwitchEvent)
+
+//Sentinel监听的参数，key相同的客户端共用一个监听
+type sentinelConfig struct {
+	key       string
+	addresses []string
+	master    string
+	//连接Sentinel的参数，与go-redis连接Sentinel时使用的参数相同
+	options redis.Options
+}
+
+type sentinelWatcher struct {
+	sentinelConfig
+	//使用这个监听的客户端数量，由sentinelMu保护
+	refs     int
+	worker   *worker
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+var (
+	sentinelMu            sync.Mutex
+	sentinelHandlers      []MasterSwitchHandler
+	sentinelErrorHandlers []func(err error)
+	sentinelWatchers      = make(map[string]*sentinelWatcher)
+)
+
+//注册主节点切换通知，Sentinel完成故障转移后回调
+func OnMasterSwitch(handler MasterSwitchHandler) {
+	sentinelMu.Lock()
+	defer sentinelMu.Unlock()
+	sentinelHandlers = append(sentinelHandlers, handler)
+}
+
+//注册Sentinel监听的错误通知，连接Sentinel失败或订阅断开时回调，监听会轮换到下一个Sentinel重试
+func OnSentinelError(handler func(err error)) {
+	sentinelMu.Lock()
+	defer sentinelMu.Unlock()
+	sentinelErrorHandlers = append(sentinelErrorHandlers, handler)
+}
+
+func newSentinelConfig(opt *Options, tlsConfig *tls.Config) *sentinelConfig {
+	config := &sentinelConfig{
+		addresses: opt.SentinelAddress,
+		master:    opt.SentinelMaster,
+		options: redis.Options{
+			MaxRetries:         opt.maxRetries(),
+			DialTimeout:        opt.DialTimeout,
+			ReadTimeout:        opt.ReadTimeout,
+			WriteTimeout:       opt.WriteTimeout,
+			PoolSize:           opt.PoolSize,
+			PoolTimeout:        opt.PoolTimeout,
+			IdleTimeout:        opt.IdleTimeout,
+			IdleCheckFrequency: opt.IdleCheckFrequency,
+		},
+	}
+	//tls.Config每次创建都是新的对象，使用TLS参数区分
+	config.key = fmt.Sprintf("%s|%s|%+v|%+v", config.master, strings.Join(config.addresses, ","), config.options, opt.TLS)
+	config.options.TLSConfig = tlsConfig
+	return config
+}
+
+//监听Sentinel的+switch-master频道，相同配置的客户端共用一个监听，不再使用时调用release
+//热加载修改Sentinel地址或连接参数后会启动新的监听，旧的监听在没有客户端使用时停止
+func acquireSentinelWatcher(config *sentinelConfig) *sentinelWatcher {
+	sentinelMu.Lock()
+	defer sentinelMu.Unlock()
+	if w, exists := sentinelWatchers[config.key]; exists {
+		w.refs++
+		return w
+	}
+
+	w := &sentinelWatcher{
+		sentinelConfig: *config,
+		refs:           1,
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	sentinelWatchers[config.key] = w
+	w.worker = registerWorker("sentinel watcher "+config.master, w.close)
+	go w.run()
+	return w
+}
+
+//客户端不再使用监听，最后一个客户端释放时停止监听，不等待监听协程结束
+func (w *sentinelWatcher) release() {
+	sentinelMu.Lock()
+	w.refs--
+	if w.refs > 0 {
+		sentinelMu.Unlock()
+		return
+	}
+	if sentinelWatchers[w.key] == w {
+		delete(sentinelWatchers, w.key)
+	}
+	sentinelMu.Unlock()
+
+	w.worker.unregister()
+	w.stopOnce.Do(func() { close(w.stop) })
+}
+
+func (w *sentinelWatcher) run() {
+	defer close(w.done)
+	for i := 0; ; i++ {
+		//当前Sentinel不可用时轮换到下一个
+		addr := w.addresses[i%len(w.addresses)]
+		err := w.listen(addr)
+
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+		w.report(fmt.Errorf("sentinel %s watching master %s: %v", addr, w.master, err))
+
+		select {
+		case <-w.stop:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (w *sentinelWatcher) listen(addr string) error {
+	options := w.options
+	options.Addr = addr
+	sentinel := redis.NewSentinelClient(&options)
+	defer sentinel.Close()
+
+	pubsub := sentinel.Subscribe("+switch-master")
+	defer pubsub.Close()
+
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-w.stop:
+			pubsub.Close()
+		case <-finished:
+		}
+	}()
+
+	for {
+		msg, err := pubsub.ReceiveMessage()
+		if err != nil {
+			return err
+		}
+
+		//消息格式: <master name> <old ip> <old port> <new ip> <new port>
+		parts := strings.Fields(msg.Payload)
+		if len(parts) != 5 || parts[0] != w.master {
+			continue
+		}
+		event := MasterSwitchEvent{
+			MasterName: parts[0],
+			OldAddress: parts[1] + ":" + parts[2],
+			NewAddress: parts[3] + ":" + parts[4],
+		}
+
+		sentinelMu.Lock()
+		handlers := append([]MasterSwitchHandler(nil), sentinelHandlers...)
+		sentinelMu.Unlock()
+		for _, handler := range handlers {
+			handler(event)
+		}
+	}
+}
+
+func (w *sentinelWatcher) report(err error) {
+	sentinelMu.Lock()
+	handlers := append([](func(err error))(nil), sentinelErrorHandlers...)
+	sentinelMu.Unlock()
+	for _, handler := range handlers {
+		handler(err)
+	}
+}
+
+//Shutdown时停止，之后客户端的release不再有影响
+func (w *sentinelWatcher) close(ctx context.Context) error {
+	sentinelMu.Lock()
+	if sentinelWatchers[w.key] == w {
+		delete(sentinelWatchers, w.key)
+	}
+	sentinelMu.Unlock()
+
+	w.stopOnce.Do(func() { close(w.stop) })
+	return waitDone(ctx, w.done)
+}
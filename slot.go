@@ -0,0 +1,111 @@
+package redis_kits
+
+import (
+	"fmt"
+	"github.com/go-redis/redis"
+	"strings"
+)
+
+//Cluster的slot数量
+const clusterSlots = 16384
+
+//CRC16/XMODEM，Redis Cluster使用的key哈希算法
+var crc16Table = func() [256]uint16 {
+	var table [256]uint16
+	for i := range table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+func crc16(key string) uint16 {
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^key[i]]
+	}
+	return crc
+}
+
+//计算key所在的slot，key包含非空的{hash tag}时只使用第一个{}中的内容计算
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) % clusterSlots
+}
+
+//检查所有key是否位于同一个slot，Cluster上多key命令和Lua脚本要求所有key在同一个slot
+func SameSlot(keys ...string) error {
+	for i := 1; i < len(keys); i++ {
+		if KeySlot(keys[i]) != KeySlot(keys[0]) {
+			return fmt.Errorf("keys %s (slot %d) and %s (slot %d) are in different slots, use the same {hash tag}",
+				keys[0], KeySlot(keys[0]), keys[i], KeySlot(keys[i]))
+		}
+	}
+	return nil
+}
+
+//检查所有key位于同一个slot后执行Lua脚本
+func RunScript(client RedisClient, script *redis.Script, keys []string, args ...interface{}) (interface{}, error) {
+	if err := SameSlot(keys...); err != nil {
+		return nil, err
+	}
+	return script.Run(client.GetRaw(), keys, args...).Result()
+}
+
+//构造带{hash tag}的key，同一个tag的key在Cluster上位于同一个slot，可以在一个Lua脚本或RENAME中使用
+//  b := NewKeyBuilder("ORDER")
+//  b.Key("1001")          ORDER:{1001}
+//  b.Key("1001", "items") ORDER:{1001}:items
+type KeyBuilder struct {
+	prefix string
+}
+
+func NewKeyBuilder(prefix string) KeyBuilder {
+	return KeyBuilder{prefix: prefix}
+}
+
+//检查tag是否可以用于KeyBuilder.Key，tag不能为空，也不能包含{和}，否则Cluster会按错误的tag计算slot
+func ValidateTag(tag string) error {
+	if len(tag) == 0 || strings.ContainsAny(tag, "{}") {
+		return fmt.Errorf("invalid hash tag %q: must be non-empty and must not contain { or }", tag)
+	}
+	return nil
+}
+
+//tag需要先经过ValidateTag检查，无效的tag会panic
+func (b KeyBuilder) Key(tag string, parts ...string) string {
+	if err := ValidateTag(tag); err != nil {
+		panic("redis_kits: " + err.Error())
+	}
+	var sb strings.Builder
+	sb.WriteString(b.prefix)
+	sb.WriteString(":{")
+	sb.WriteString(tag)
+	sb.WriteString("}")
+	for _, part := range parts {
+		sb.WriteString(":")
+		sb.WriteString(part)
+	}
+	return sb.String()
+}
+
+//按slot分组，组内保持原有顺序
+func groupBySlot(keys []string) map[int][]string {
+	groups := make(map[int][]string)
+	for _, key := range keys {
+		slot := KeySlot(key)
+		groups[slot] = append(groups[slot], key)
+	}
+	return groups
+}
@@ -0,0 +1,317 @@
+package redis_kits
+
+import (
+	"context"
+	"github.com/go-redis/redis"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Stream的参数
+type StreamOptions struct {
+	//XADD时裁剪到的最大长度，为0时不裁剪
+	MaxLen int64
+	//使用MAXLEN ~近似裁剪，性能更好但长度可能略大于MaxLen
+	Approx bool
+}
+
+//Redis Stream的生产者和消费组，单个Stream保存在一个key中，可以在Cluster上使用
+type Stream struct {
+	client RedisClient
+	key    string
+	opt    StreamOptions
+}
+
+//消息处理函数，返回nil时确认消息，返回错误时消息保留在待确认列表中，等待重新处理或被其他消费者认领
+type StreamHandler func(msg redis.XMessage) error
+
+//Consume的参数
+type ConsumeOptions struct {
+	//每次读取的消息数量，默认10
+	Count int64
+	//没有新消息时阻塞等待的时间，也是停止消费时最长的等待时间，默认5秒
+	Block time.Duration
+	//认领超过MinIdle未确认的消息重新处理，包括其他消费者卡住和自己处理失败的消息，为0时不认领
+	MinIdle time.Duration
+	//检查可认领消息的间隔，默认为MinIdle
+	ClaimInterval time.Duration
+	//读取、处理或确认失败时回调
+	OnError func(err error)
+}
+
+func NewStream(client RedisClient, key string, opt *StreamOptions) *Stream {
+	s := &Stream{client: client, key: key}
+	if opt != nil {
+		s.opt = *opt
+	}
+	return s
+}
+
+//添加消息，返回消息ID
+func (s *Stream) Add(values map[string]interface{}) (string, error) {
+	args := &redis.XAddArgs{Stream: s.key, Values: values}
+	if s.opt.Approx {
+		args.MaxLenApprox = s.opt.MaxLen
+	} else {
+		args.MaxLen = s.opt.MaxLen
+	}
+	return s.client.GetRaw().XAdd(args).Result()
+}
+
+//Stream中的消息数量
+func (s *Stream) Len() (int64, error) {
+	return s.client.GetRaw().XLen(s.key).Result()
+}
+
+//创建消费组，Stream不存在时自动创建，消费组已经存在时不返回错误
+//start为消费组开始读取的位置，"$"表示只读取新消息，"0"表示从头读取，为空时使用"$"
+func (s *Stream) CreateGroup(group string, start string) error {
+	if len(start) == 0 {
+		start = "$"
+	}
+	err := s.client.GetRaw().XGroupCreateMkStream(s.key, group, start).Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+//确认消息，返回确认的数量
+func (s *Stream) Ack(group string, ids ...string) (int64, error) {
+	return s.client.GetRaw().XAck(s.key, group, ids...).Result()
+}
+
+//消费组待确认消息的汇总
+func (s *Stream) Pending(group string) (*redis.XPending, error) {
+	return s.client.GetRaw().XPending(s.key, group).Result()
+}
+
+//消费组待确认消息的明细，consumer为空时返回所有消费者的消息
+func (s *Stream) PendingEntries(group string, consumer string, count int64) ([]redis.XPendingExt, error) {
+	return s.client.GetRaw().XPendingExt(&redis.XPendingExtArgs{
+		Stream:   s.key,
+		Group:    group,
+		Start:    "-",
+		End:      "+",
+		Count:    count,
+		Consumer: consumer,
+	}).Result()
+}
+
+//将消费组中超过minIdle未确认的消息认领给consumer，包括consumer自己处理失败的消息，
+//最多检查count条待确认消息，返回认领到的消息
+//使用XPENDING和XCLAIM实现，兼容不支持XAUTOCLAIM的Redis版本
+//已经被MAXLEN裁剪或XDEL删除的消息无法处理，认领后直接确认，从待确认列表中移除
+func (s *Stream) Claim(group string, consumer string, minIdle time.Duration, count int64) ([]redis.XMessage, error) {
+	entries, err := s.PendingEntries(group, "", count)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		if entry.Idle >= minIdle {
+			ids = append(ids, entry.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	//消息已经被删除时XCLAIM返回nil，go-redis无法解析，所以只认领ID再用XRANGE读取
+	claimed, err := s.client.GetRaw().XClaimJustID(&redis.XClaimArgs{
+		Stream:   s.key,
+		Group:    group,
+		Consumer: consumer,
+		MinIdle:  minIdle,
+		Messages: ids,
+	}).Result()
+	if err != nil || len(claimed) == 0 {
+		return nil, err
+	}
+
+	p := s.client.GetRaw().Pipeline()
+	defer p.Close()
+	cmds := make([]*redis.XMessageSliceCmd, len(claimed))
+	for i, id := range claimed {
+		cmds[i] = p.XRange(s.key, id, id)
+	}
+	if _, err := p.Exec(); err != nil {
+		return nil, err
+	}
+
+	var messages []redis.XMessage
+	var deleted []string
+	for i, cmd := range cmds {
+		if len(cmd.Val()) == 0 {
+			deleted = append(deleted, claimed[i])
+			continue
+		}
+		messages = append(messages, cmd.Val()[0])
+	}
+	if len(deleted) != 0 {
+		if _, err := s.Ack(group, deleted...); err != nil {
+			return messages, err
+		}
+	}
+	return messages, nil
+}
+
+//在后台以consumer的身份消费group中的消息，消费组不存在时创建
+//先处理consumer自己未确认的消息，再读取新消息，设置MinIdle时定时认领其他消费者卡住的消息
+//Shutdown时停止，返回停止的函数
+func (s *Stream) Consume(group string, consumer string, handler StreamHandler, opt *ConsumeOptions) (stop func()) {
+	o := ConsumeOptions{}
+	if opt != nil {
+		o = *opt
+	}
+	if o.Count <= 0 {
+		o.Count = 10
+	}
+	if o.Block <= 0 {
+		o.Block = 5 * time.Second
+	}
+	if o.ClaimInterval <= 0 {
+		o.ClaimInterval = o.MinIdle
+	}
+
+	c := &streamConsumer{
+		stream:   s,
+		group:    group,
+		consumer: consumer,
+		handler:  handler,
+		opt:      o,
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	go c.run()
+
+	var once sync.Once
+	shutdown := func(ctx context.Context) error {
+		once.Do(func() { close(c.done) })
+		return waitDone(ctx, c.finished)
+	}
+	w := registerWorker("stream consumer "+s.key+" "+group+" "+consumer, shutdown)
+	return func() {
+		w.unregister()
+		shutdown(context.Background())
+	}
+}
+
+type streamConsumer struct {
+	stream   *Stream
+	group    string
+	consumer string
+	handler  StreamHandler
+	opt      ConsumeOptions
+
+	done     chan struct{}
+	finished chan struct{}
+}
+
+func (c *streamConsumer) run() {
+	defer close(c.finished)
+
+	for {
+		err := c.stream.CreateGroup(c.group, "$")
+		if err == nil {
+			break
+		}
+		c.report(err)
+		if !c.sleep(time.Second) {
+			return
+		}
+	}
+
+	//"0"读取自己未确认的消息，处理完后使用">"读取新消息
+	start := "0"
+	lastClaim := time.Now()
+	for !c.stopped() {
+		if c.opt.MinIdle > 0 && time.Since(lastClaim) >= c.opt.ClaimInterval {
+			lastClaim = time.Now()
+			claimed, err := c.stream.Claim(c.group, c.consumer, c.opt.MinIdle, c.opt.Count)
+			if err != nil {
+				c.report(err)
+			}
+			c.handle(claimed)
+		}
+
+		block := c.opt.Block
+		if start != ">" {
+			block = -1
+		}
+		streams, err := c.stream.client.GetRaw().XReadGroup(&redis.XReadGroupArgs{
+			Group:    c.group,
+			Consumer: c.consumer,
+			Streams:  []string{c.stream.key, start},
+			Count:    c.opt.Count,
+			Block:    block,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			c.report(err)
+			if !c.sleep(time.Second) {
+				return
+			}
+			continue
+		}
+
+		var messages []redis.XMessage
+		for _, stream := range streams {
+			messages = append(messages, stream.Messages...)
+		}
+		if start != ">" {
+			if len(messages) == 0 {
+				start = ">"
+			} else {
+				//处理失败的消息仍然是未确认状态，从最后一条之后继续读取
+				start = messages[len(messages)-1].ID
+			}
+		}
+		c.handle(messages)
+	}
+}
+
+func (c *streamConsumer) handle(messages []redis.XMessage) {
+	for _, msg := range messages {
+		if c.stopped() {
+			return
+		}
+		if err := c.handler(msg); err != nil {
+			c.report(err)
+			continue
+		}
+		if _, err := c.stream.Ack(c.group, msg.ID); err != nil {
+			c.report(err)
+		}
+	}
+}
+
+func (c *streamConsumer) report(err error) {
+	if c.opt.OnError != nil {
+		c.opt.OnError(err)
+	}
+}
+
+func (c *streamConsumer) stopped() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+//等待d，期间停止时返回false
+func (c *streamConsumer) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.done:
+		return false
+	}
+}
@@ -0,0 +1,334 @@
+package redis_kits
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+//订阅消息的处理函数，返回的错误通过OnError回调
+type MessageHandler func(msg *redis.Message) error
+
+//Subscriber的参数
+type SubscriberOptions struct {
+	//处理消息的协程数量，默认1，大于1时同一个频道的消息不保证顺序
+	Workers int
+	//等待处理的消息数量上限，默认100，队列满时接收会等待
+	BufferSize int
+	//连接断开后重新订阅的间隔，默认1秒
+	ReconnectDelay time.Duration
+	//没有消息时发送PING检查连接的间隔，默认30秒
+	PingInterval time.Duration
+	//连接断开、处理消息失败和收到没有处理函数的消息时回调
+	OnError func(err error)
+}
+
+//Subscriber的运行状态
+type SubscriberHealth struct {
+	//当前是否已经订阅成功
+	Connected bool
+	//重新订阅的次数
+	Reconnects int64
+	//最后一次错误
+	LastError error
+	//最后一次收到消息的时间
+	LastMessage time.Time
+	//等待处理的消息数量
+	Pending  int
+	Channels []string
+	Patterns []string
+}
+
+//订阅管理，按频道或模式注册处理函数，消息由协程池处理，连接断开或客户端热加载后自动重新订阅
+//  s := NewSubscriber(client, nil)
+//  s.Handle("orders", func(msg *redis.Message) error { ... })
+//  s.HandlePattern("user.*", func(msg *redis.Message) error { ... })
+//  if err := s.Start(); err != nil {
+//  }
+//  defer s.Stop(ctx)
+type Subscriber struct {
+	client RedisClient
+	opt    SubscriberOptions
+
+	mu       sync.Mutex
+	channels map[string]MessageHandler
+	patterns map[string]MessageHandler
+	//当前的订阅连接，未连接时为nil
+	pubsub *redis.PubSub
+	health SubscriberHealth
+	worker *worker
+
+	started  bool
+	jobs     chan *redis.Message
+	done     chan struct{}
+	finished chan struct{}
+	workers  sync.WaitGroup
+	stopOnce sync.Once
+}
+
+//可以创建订阅连接的客户端
+type pubsubClient interface {
+	newPubSub() *redis.PubSub
+}
+
+//创建没有订阅任何频道的连接，Cluster上由go-redis选择节点
+func (c *redisStandard) newPubSub() *redis.PubSub {
+	return c.conn().Subscribe()
+}
+
+func (c *redisCluster) newPubSub() *redis.PubSub {
+	return c.conn().Subscribe()
+}
+
+func NewSubscriber(client RedisClient, opt *SubscriberOptions) *Subscriber {
+	s := &Subscriber{
+		client:   client,
+		channels: make(map[string]MessageHandler),
+		patterns: make(map[string]MessageHandler),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	if opt != nil {
+		s.opt = *opt
+	}
+	if s.opt.Workers <= 0 {
+		s.opt.Workers = 1
+	}
+	if s.opt.BufferSize <= 0 {
+		s.opt.BufferSize = 100
+	}
+	if s.opt.ReconnectDelay <= 0 {
+		s.opt.ReconnectDelay = time.Second
+	}
+	if s.opt.PingInterval <= 0 {
+		s.opt.PingInterval = 30 * time.Second
+	}
+	s.jobs = make(chan *redis.Message, s.opt.BufferSize)
+	return s
+}
+
+//注册频道的处理函数，已经启动时立即订阅
+func (s *Subscriber) Handle(channel string, handler MessageHandler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.channels[channel] = handler
+	if s.pubsub != nil {
+		return s.pubsub.Subscribe(channel)
+	}
+	return nil
+}
+
+//注册模式的处理函数，使用PSUBSCRIBE订阅，已经启动时立即订阅
+func (s *Subscriber) HandlePattern(pattern string, handler MessageHandler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.patterns[pattern] = handler
+	if s.pubsub != nil {
+		return s.pubsub.PSubscribe(pattern)
+	}
+	return nil
+}
+
+//启动订阅和处理协程，Shutdown时停止
+func (s *Subscriber) Start() error {
+	client, ok := s.client.(pubsubClient)
+	if !ok {
+		return errors.New("client does not support subscriber")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return errors.New("subscriber already started")
+	}
+	s.started = true
+
+	for i := 0; i < s.opt.Workers; i++ {
+		s.workers.Add(1)
+		go s.work()
+	}
+	go s.run(client)
+	s.worker = registerWorker("subscriber", s.shutdown)
+	return nil
+}
+
+//停止订阅，等待已经收到的消息处理完成，超过ctx期限时返回ctx的错误
+func (s *Subscriber) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	w := s.worker
+	s.mu.Unlock()
+	if w != nil {
+		w.unregister()
+	}
+	return s.shutdown(ctx)
+}
+
+func (s *Subscriber) shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	started := s.started
+	s.mu.Unlock()
+	if !started {
+		return nil
+	}
+
+	s.stopOnce.Do(func() {
+		close(s.done)
+		s.mu.Lock()
+		if s.pubsub != nil {
+			s.pubsub.Close()
+		}
+		s.mu.Unlock()
+	})
+
+	all := make(chan struct{})
+	go func() {
+		<-s.finished
+		s.workers.Wait()
+		close(all)
+	}()
+	return waitDone(ctx, all)
+}
+
+//当前的运行状态
+func (s *Subscriber) Health() SubscriberHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	health := s.health
+	health.Pending = len(s.jobs)
+	health.Channels = sortedKeys(s.channels)
+	health.Patterns = sortedKeys(s.patterns)
+	return health
+}
+
+func sortedKeys(handlers map[string]MessageHandler) []string {
+	keys := make([]string, 0, len(handlers))
+	for key := range handlers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Subscriber) run(client pubsubClient) {
+	defer close(s.finished)
+	defer close(s.jobs)
+
+	for {
+		err := s.listen(client)
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		s.mu.Lock()
+		s.health.Connected = false
+		if err != nil {
+			s.health.LastError = err
+		}
+		s.mu.Unlock()
+		s.report(err)
+
+		timer := time.NewTimer(s.opt.ReconnectDelay)
+		select {
+		case <-s.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.mu.Lock()
+		s.health.Reconnects++
+		s.mu.Unlock()
+	}
+}
+
+//订阅所有注册的频道和模式并接收消息，连接断开或停止时返回
+func (s *Subscriber) listen(client pubsubClient) error {
+	pubsub := client.newPubSub()
+	defer pubsub.Close()
+
+	s.mu.Lock()
+	select {
+	case <-s.done:
+		s.mu.Unlock()
+		return nil
+	default:
+	}
+	var err error
+	if channels := sortedKeys(s.channels); len(channels) != 0 {
+		err = pubsub.Subscribe(channels...)
+	}
+	if patterns := sortedKeys(s.patterns); err == nil && len(patterns) != 0 {
+		err = pubsub.PSubscribe(patterns...)
+	}
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.pubsub = pubsub
+	s.health.Connected = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.pubsub = nil
+		s.mu.Unlock()
+	}()
+
+	for {
+		received, err := pubsub.ReceiveTimeout(s.opt.PingInterval)
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				if err := pubsub.Ping(); err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+
+		msg, ok := received.(*redis.Message)
+		if !ok {
+			continue
+		}
+		s.mu.Lock()
+		s.health.LastMessage = time.Now()
+		s.mu.Unlock()
+
+		select {
+		case s.jobs <- msg:
+		case <-s.done:
+			return nil
+		}
+	}
+}
+
+func (s *Subscriber) work() {
+	defer s.workers.Done()
+	for msg := range s.jobs {
+		s.mu.Lock()
+		handler, ok := s.channels[msg.Channel]
+		if len(msg.Pattern) != 0 {
+			handler, ok = s.patterns[msg.Pattern]
+		}
+		s.mu.Unlock()
+
+		if !ok {
+			s.report(errors.New("no handler for channel " + msg.Channel))
+			continue
+		}
+		if err := handler(msg); err != nil {
+			s.report(err)
+		}
+	}
+}
+
+func (s *Subscriber) report(err error) {
+	if err != nil && s.opt.OnError != nil {
+		s.opt.OnError(err)
+	}
+}
@@ -0,0 +1,144 @@
+package redis_kits
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//解析Redis URL，支持以下格式:
+//redis://[[username]:password@]host[:port][/db][?pool_size=20&min_idle=5&dial_timeout=3s]
+//rediss://... 使用TLS连接
+//unix://[:password@]/path/to/redis.sock[?db=0]
+//redis://host1:port,host2:port/ 多个节点时作为Cluster地址
+func ParseURL(rawurl string) (*Options, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		//url.Error包含完整的URL，其中可能有密码
+		if e, ok := err.(*url.Error); ok {
+			err = e.Err
+		}
+		return nil, fmt.Errorf("invalid redis URL: %v", err)
+	}
+
+	opt := &Options{}
+	if u.User != nil {
+		opt.Username = u.User.Username()
+		opt.Password, _ = u.User.Password()
+	}
+
+	switch u.Scheme {
+	case "redis", "rediss":
+		if err := parseURLHosts(opt, u.Host); err != nil {
+			return nil, err
+		}
+		if db := strings.Trim(u.Path, "/"); len(db) != 0 {
+			if opt.Database, err = strconv.Atoi(db); err != nil {
+				return nil, fmt.Errorf("invalid database %q in redis URL", db)
+			}
+		}
+		if u.Scheme == "rediss" {
+			opt.TLS = &TLSOptions{}
+		}
+	case "unix":
+		if len(u.Path) == 0 {
+			return nil, errors.New("redis URL has no socket path")
+		}
+		opt.Network = "unix"
+		opt.Address = u.Path
+	default:
+		return nil, fmt.Errorf("invalid redis URL scheme %q", u.Scheme)
+	}
+
+	if err := parseURLQuery(opt, u.Query()); err != nil {
+		return nil, err
+	}
+	return opt, nil
+}
+
+func parseURLHosts(opt *Options, hosts string) error {
+	addrs := strings.Split(hosts, ",")
+	for i, addr := range addrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			host, port = addr, ""
+		}
+		if len(host) == 0 {
+			return errors.New("redis URL has empty host")
+		}
+		if len(port) == 0 {
+			port = "6379"
+		}
+		addrs[i] = net.JoinHostPort(host, port)
+
+		if len(addrs) == 1 {
+			opt.Address = host
+			if opt.Port, err = strconv.Atoi(port); err != nil {
+				return fmt.Errorf("invalid port %q in redis URL", port)
+			}
+		}
+	}
+
+	if len(addrs) > 1 {
+		opt.ClusterAddress = addrs
+	}
+	return nil
+}
+
+func parseURLQuery(opt *Options, query url.Values) error {
+	var err error
+	for name := range query {
+		value := query.Get(name)
+		switch name {
+		case "db":
+			opt.Database, err = strconv.Atoi(value)
+		case "pool_size":
+			opt.PoolSize, err = strconv.Atoi(value)
+		case "min_idle":
+			opt.MinIdle, err = strconv.Atoi(value)
+		case "max_retries":
+			opt.MaxRetries, err = strconv.Atoi(value)
+			opt.MaxRetries = explicitRetries(opt.MaxRetries)
+		case "min_retry_backoff":
+			opt.MinRetryBackoff, err = parseDuration(value)
+		case "max_retry_backoff":
+			opt.MaxRetryBackoff, err = parseDuration(value)
+		case "dial_timeout":
+			opt.DialTimeout, err = parseDuration(value)
+		case "read_timeout":
+			opt.ReadTimeout, err = parseDuration(value)
+		case "write_timeout":
+			opt.WriteTimeout, err = parseDuration(value)
+		case "pool_timeout":
+			opt.PoolTimeout, err = parseDuration(value)
+		case "idle_timeout":
+			opt.IdleTimeout, err = parseDuration(value)
+		case "idle_check_frequency":
+			opt.IdleCheckFrequency, err = parseDuration(value)
+		case "max_conn_age":
+			opt.MaxConnAge, err = parseDuration(value)
+		default:
+			return fmt.Errorf("unsupported redis URL option %q", name)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid redis URL option %s=%q", name, value)
+		}
+	}
+	return nil
+}
+
+//解析时长，纯数字按毫秒处理
+func parseDuration(value string) (time.Duration, error) {
+	if value == "-1" {
+		//go-redis中-1表示不超时
+		return -1, nil
+	}
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	return time.ParseDuration(value)
+}